@@ -0,0 +1,161 @@
+package mold
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// lruCache is a cache that is safe for concurrent use.
+// When bounded, the least recently used entries are evicted, approximated by giving entries used since
+// they were last considered for eviction a second chance. Hits only take a read lock, so that concurrent
+// renders are not serialized.
+// When ttl is set, entries expire after the duration.
+type lruCache[K comparable, V any] struct {
+	mu    sync.RWMutex
+	size  int           // 0 means unbounded
+	ttl   time.Duration // 0 means no expiry
+	ll    *list.List
+	items map[K]*list.Element
+}
+
+type cacheEntry[K comparable, V any] struct {
+	key     K
+	val     V
+	expires time.Time
+	used    atomic.Bool // since last considered for eviction
+}
+
+func newLRUCache[K comparable, V any](size int, ttl time.Duration) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: map[K]*list.Element{},
+	}
+}
+
+// viewCache is a cache of compiled views.
+type viewCache = lruCache[string, *compiledView]
+
+func newViewCache(size int) *viewCache {
+	return newLRUCache[string, *compiledView](size, 0)
+}
+
+// get returns the cached entry and marks it as recently used.
+func (c *lruCache[K, V]) get(key K) (val V, ok bool) {
+	c.mu.RLock()
+	e, ok := c.items[key]
+	if !ok {
+		c.mu.RUnlock()
+		return val, false
+	}
+	entry := e.Value.(*cacheEntry[K, V])
+	if c.ttl > 0 && time.Now().After(entry.expires) {
+		c.mu.RUnlock()
+		c.remove(key, e)
+		return val, false
+	}
+	if c.size > 0 && !entry.used.Load() {
+		entry.used.Store(true)
+	}
+	val = entry.val // replaced in place by add once unlocked
+	c.mu.RUnlock()
+	return val, true
+}
+
+// remove removes the entry of the key, unless it was replaced.
+func (c *lruCache[K, V]) remove(key K, e *list.Element) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.items[key] == e {
+		c.ll.Remove(e)
+		delete(c.items, key)
+	}
+}
+
+// add adds the entry to the cache, replacing any existing entry with the same key.
+func (c *lruCache[K, V]) add(key K, val V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expires time.Time
+	if c.ttl > 0 {
+		expires = time.Now().Add(c.ttl)
+	}
+
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		entry := e.Value.(*cacheEntry[K, V])
+		entry.val, entry.expires = val, expires
+		entry.used.Store(false)
+		return
+	}
+
+	// room is made before adding the entry, so that it is not evicted itself
+	for c.size > 0 && c.ll.Len() >= c.size {
+		e := c.ll.Back()
+		entry := e.Value.(*cacheEntry[K, V])
+		if entry.used.Swap(false) {
+			c.ll.MoveToFront(e)
+			continue
+		}
+		c.ll.Remove(e)
+		delete(c.items, entry.key)
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry[K, V]{key: key, val: val, expires: expires})
+}
+
+// purge removes all entries.
+func (c *lruCache[K, V]) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ll.Init()
+	clear(c.items)
+}
+
+// len returns the number of cached entries.
+func (c *lruCache[K, V]) len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.ll.Len()
+}
+
+// maxPooledBuffer is the capacity above which buffers are not returned to the pool,
+// preventing a few large renders from being retained by the pool.
+const maxPooledBuffer = 1 << 20
+
+// bufferPool recycles the buffers of buffered renders, see [WithBufferPool].
+// A nil pool allocates a new buffer for each render.
+type bufferPool struct {
+	pool sync.Pool
+	size int // initial capacity of new buffers
+}
+
+func newBufferPool(size int) *bufferPool {
+	p := &bufferPool{size: size}
+	p.pool.New = func() any { return bytes.NewBuffer(make([]byte, 0, size)) }
+	return p
+}
+
+// get returns an empty buffer.
+func (p *bufferPool) get() *bytes.Buffer {
+	if p == nil {
+		return &bytes.Buffer{}
+	}
+	return p.pool.Get().(*bytes.Buffer)
+}
+
+// put returns the buffer to the pool, it must not be used afterwards.
+func (p *bufferPool) put(buf *bytes.Buffer) {
+	if p == nil || buf.Cap() > max(maxPooledBuffer, p.size) {
+		return
+	}
+	buf.Reset()
+	p.pool.Put(buf)
+}
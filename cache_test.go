@@ -0,0 +1,98 @@
+package mold
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestViewCache(t *testing.T) {
+	cache := newViewCache(2)
+
+	a, b, c := &compiledView{}, &compiledView{}, &compiledView{}
+	cache.add("a", a)
+	cache.add("b", b)
+
+	// a is now the most recently used
+	if v, ok := cache.get("a"); !ok || v != a {
+		t.Errorf("get() = %v, %v, expected a", v, ok)
+	}
+
+	cache.add("c", c)
+	if _, ok := cache.get("b"); ok {
+		t.Errorf("get() expected b to be evicted")
+	}
+	for name, expected := range map[string]*compiledView{"a": a, "c": c} {
+		if v, ok := cache.get(name); !ok || v != expected {
+			t.Errorf("get(%q) = %v, %v, expected cached view", name, v, ok)
+		}
+	}
+	if cache.len() != 2 {
+		t.Errorf("len() = %d, expected 2", cache.len())
+	}
+}
+
+func TestViewCache_Single(t *testing.T) {
+	cache := newViewCache(1)
+
+	a, b := &compiledView{}, &compiledView{}
+	cache.add("a", a)
+	cache.get("a")
+
+	// the entry added is retained, although a was recently used
+	cache.add("b", b)
+	if v, ok := cache.get("b"); !ok || v != b {
+		t.Errorf("get() = %v, %v, expected b", v, ok)
+	}
+	if _, ok := cache.get("a"); ok {
+		t.Errorf("get() expected a to be evicted")
+	}
+	if cache.len() != 1 {
+		t.Errorf("len() = %d, expected 1", cache.len())
+	}
+}
+
+func TestViewCache_Unbounded(t *testing.T) {
+	cache := newViewCache(0)
+
+	for _, name := range []string{"a", "b", "c", "a"} {
+		cache.add(name, &compiledView{})
+	}
+	if cache.len() != 3 {
+		t.Errorf("len() = %d, expected 3", cache.len())
+	}
+}
+
+func TestLRUCache_TTL(t *testing.T) {
+	cache := newLRUCache[string, int](0, time.Millisecond)
+
+	cache.add("a", 1)
+	if v, ok := cache.get("a"); !ok || v != 1 {
+		t.Errorf("get() = %v, %v, expected 1", v, ok)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if _, ok := cache.get("a"); ok {
+		t.Errorf("get() expected a to be expired")
+	}
+	if cache.len() != 0 {
+		t.Errorf("len() = %d, expected 0", cache.len())
+	}
+}
+
+// BenchmarkViewCache_Parallel measures concurrent hits, which do not take an exclusive lock.
+func BenchmarkViewCache_Parallel(b *testing.B) {
+	cache := newViewCache(100)
+	for i := range 100 {
+		cache.add(strconv.Itoa(i), &compiledView{})
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, ok := cache.get(strconv.Itoa(i % 100)); !ok {
+				b.Error("get() expected hit")
+			}
+			i++
+		}
+	})
+}
@@ -38,6 +38,20 @@ To render a specific section, pass the section's name as an argument.
 
 	</html>
 
+A view may instead be wrapped by a stack of layouts with the "layouts" directive, ordered from the
+outermost to the innermost. The body of each layout renders the next inner layout and the innermost
+renders the view. Sections defined by the view are available to all layouts in the stack.
+
+	{{layouts "outer_layout.html" "card_layout.html"}}
+
+The layout may also be chosen at render time with [Engine.RenderWithLayout], e.g. for a print version of a page.
+
+	engine.RenderWithLayout(w, "print_layout.html", "article.html", data)
+
+Views matching the patterns configured with [WithStandaloneViews], e.g. HTMX fragments, are rendered without a layout.
+
+	option := mold.WithStandaloneViews("fragments/*.html")
+
 Views are templates that generate the content that is inserted into the body of layouts.
 Typically what you would put in the "<body>" tag of an HTML page.
 
@@ -51,21 +65,133 @@ Sections allow content to be rendered in specific parts of the layout.
 They are defined within views with a "define" block.
 
 The default layout is able to render HTML content within the "<head>" tag by utilising the "head" section.
+The name of the head section is configured with [WithHeadSection]. Views not defining it leave it empty,
+unless disabled with [WithAutoHead].
 
 	{{define "scripts"}}
 	<script src="//unpkg.com/alpinejs" defer></script>
 	{{end}}
 
+An optional second argument to "render" allows customizing the data passed to the section.
+By default, the view's data context is used.
+
+	{{render "breadcrumb" .Nav}}
+
 Partials are reusable template snippets that allow you to break down complex views into smaller,
-manageable components. They are supported in both views and layouts with the "partial" function.
+manageable components. They are supported in views, layouts and other partials with the "partial" function.
+Cyclic references between partials are reported as errors, as are templates invoking each other
+unconditionally, e.g. a section rendering the body which renders the section.
 
 Partials are ideal for sharing common logic across multiple views and layouts.
 
 	{{partial "path/to/partial.html"}}
 
+Templates defined by a partial with a "define" block are scoped to the partial. They are not sections,
+and neither collide with the templates of the same name defined by views or other partials.
+
 An optional second argument allows customizing the data passed to the partial.
-By default, the view's data context is used.
+By default, the view's data context is used. As with the standard "template" action,
+the argument may be any value, e.g. a field, a variable, a literal or a parenthesized pipeline.
 
 	{{partial "partials/user_session.html" .User}}
+
+Multiple arguments are passed to the partial as a slice, exposed as ".Args" within the partial.
+
+	{{partial "card.html" .Title .Body}}
+	<h2>{{index .Args 0}}</h2>
+
+Arguments may instead be passed by name to "partial", "optionalPartial" and "component", as a map
+accessed by the names within the partial. They cannot be mixed with other arguments.
+
+	{{partial "button.html" label="Save" kind=.Kind}}
+	<button class="{{.kind}}">{{.label}}</button>
+
+Partial paths are resolved relative to the directory of the referencing template first,
+falling back to the root. Paths starting with "./" or "../" are always relative.
+Paths resolving outside of the root are rejected by [New].
+
+	{{partial "./_row.html" .}}
+
+The output of expensive partials can be memoized by a key with the "cachedPartial" function,
+once a cache is configured with [WithPartialCache].
+
+	{{cachedPartial "partials/big_table.html" .Version .Table}}
+
+Partials that may not exist, e.g. feature-flagged ones, can be rendered with the "optionalPartial" function,
+which renders nothing instead of failing if the partial is missing when the view is assembled.
+The partial is resolved once, by New or a rebuild with WithWatch: a partial added afterwards is
+not picked up by renders nor by Engine.Reload of the view.
+
+	{{optionalPartial "partials/promo.html" .}}
+
+The "eachPartial" function renders a partial for each element of a collection.
+
+	{{eachPartial "partials/item.html" .Items}}
+
+The "partialOr" function renders a fallback partial instead if the data is the zero value of its type, e.g. nil or a struct with zero fields. Unlike "with", an empty but non-nil slice or map is not a zero value.
+
+	{{partialOr "partials/avatar.html" .User.Photo "partials/default_avatar.html"}}
+
+Once a component directory is configured with [WithComponentDir], the "component" function renders
+the partials in it by their path relative to the directory.
+
+	{{component "button.html" .}}
+
+Partials missing from the filesystem can be provided by a [PartialResolver] configured with
+[WithPartialResolver], e.g. the partials of a parent theme, overridden by files of a child theme.
+
+Templates can also be layered in themes with [WithTheme], ordered from child to parent.
+A file in a child theme overrides the file at the same path in its parents.
+
+	option := mold.WithTheme("themes/child", "themes/parent")
+
+Partials can be rendered in place of others for a single render with [Engine.RenderWithOverrides],
+e.g. to experiment with variants of a partial.
+
+	engine.RenderWithOverrides(w, "index.html", data, mold.Overrides{"card.html": "card_v2.html"})
+
+The "lazy" function returns data computed on first use, created with [Lazy], for data that is expensive
+to compute and only needed by conditional branches. Functions without arguments are called instead.
+
+	{{if .ShowComments}}{{range lazy .Comments}}...{{end}}{{end}}
+
+The "include" function inserts the contents of a file as is, e.g. an SVG icon.
+Unlike partials, included files are not processed as templates and are not escaped.
+Hidden files, or files in hidden directories, are not included.
+
+	{{include "icons/logo.svg"}}
+
+The "safe" function marks a trusted string as HTML, preventing it from being escaped.
+The "safeURL", "safeCSS" and "safeJS" functions do the same for URLs, CSS and JavaScript respectively.
+
+	{{safe .RenderedMarkdown}}
+
+The "sanitize" function sanitizes untrusted HTML, e.g. authored by users, with the sanitizer configured
+with [WithSanitizer], and marks the output as HTML.
+
+	<div class="comment">{{sanitize .Comment}}</div>
+
+The "nonce" function returns the nonce passed as a per-render value to [Engine.RenderWith],
+e.g. for inline scripts allowed by a Content Security Policy.
+
+	<script nonce="{{nonce}}">...</script>
+
+The "t" function translates messages with the translator configured with [WithTranslator],
+in the locale passed as the "locale" per-render value.
+
+	<h1>{{t "welcome.title" .Name}}</h1>
+
+The "currentPath", "query" and "isActive" functions access the request rendered with [Engine.RenderRequest],
+e.g. to highlight the active navigation link.
+
+	<a href="/blog" {{if isActive "/blog"}}class="active"{{end}}>Blog</a>
+
+Other functions depending on the render, e.g. on the current user, can be created for each render
+by a factory configured with [WithFuncMapFactory], from the [RenderContext] of the render.
+
+Partials can also be authored in Markdown with the ".md" extension once a renderer
+is configured with [WithMarkdown]. The rendered HTML is inserted as is.
+
+	{{partial "content/about.md"}}
 */
 package mold
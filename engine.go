@@ -1,13 +1,29 @@
 package mold
 
 import (
+	"bytes"
+	"context"
 	_ "embed"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	texttemplate "text/template"
+	"text/template/parse"
+	"time"
 )
 
 // defaults
@@ -17,13 +33,76 @@ var (
 
 	// default filename extenstions for template files
 	defaultExts = []string{".html", ".gohtml", ".tpl", ".tmpl"}
-)
 
-type (
-	templateSet map[string]*templateFile
-	moldEngine  map[string]*template.Template
+	// default name of the section holding the content of views
+	defaultBodySection = "body"
+
+	// default name of the section holding the content of the "<head>" tag
+	defaultHeadSection = "head"
 )
 
+type templateSet map[string]*templateFile
+
+// resolve returns the path of the partial name referenced by the template at from.
+//
+// Paths starting with "./" or "../" are relative to the directory of from.
+// Other paths are looked up in the directory of from first, falling back to the root.
+func (s templateSet) resolve(from, name string) string {
+	rel := path.Join(path.Dir(from), name)
+	if strings.HasPrefix(name, "./") || strings.HasPrefix(name, "../") {
+		return rel
+	}
+	if _, ok := s[rel]; ok {
+		return rel
+	}
+	return name
+}
+
+// resolvePartial adds the partial at name to the set if missing and provided by the resolver
+// of the referencing template, see [WithPartialResolver].
+func (s templateSet) resolvePartial(from *templateFile, name string) error {
+	if _, ok := s[name]; ok || from.resolve == nil {
+		return nil
+	}
+	t, err := from.resolve(name)
+	if err != nil || t == nil {
+		return err
+	}
+	s[name] = t
+	return nil
+}
+
+type moldEngine struct {
+	c           Config
+	set         templateSet
+	layout      *templateFile
+	bare        *templateFile            // rendering the body only, see [WithStandaloneViews]
+	layoutFiles map[string]bool          // skipped by the walk, see [moldEngine.notFoundErr]
+	folded      map[string]string        // lowercase paths of views and aliases, see [WithCaseInsensitiveLookup]
+	stacks      map[string]*templateFile // stacked layouts by their comma-separated names, see [stackLayouts]
+
+	mu       sync.RWMutex // guards set and assembly
+	views    *viewCache
+	variants *lruCache[variant, *compiledView]    // views assembled at render time with a layout or overrides
+	partials *lruCache[partialKey, template.HTML] // nil if partials are not cached
+	watcher  *watcher                             // nil if the filesystem is not watched
+	buffers  *bufferPool                          // nil if buffers are not recycled
+}
+
+// variant identifies a view assembled differently than by default,
+// see [Engine.RenderWithLayout] and [Engine.RenderWithOverrides].
+type variant struct {
+	view      string
+	layout    string // empty for the default layouts of the view
+	overrides string // see [Overrides.key]
+}
+
+// assembly configures the assembly of a view variant.
+type assembly struct {
+	layouts   []string  // in place of the configured layout and the layouts declared by the view, unless nil
+	overrides Overrides // partials rendered in place of others
+}
+
 func newEngine(fsys fs.FS, options ...Option) (Engine, error) {
 	c := Config{
 		fs: fsys,
@@ -32,226 +111,1992 @@ func newEngine(fsys fs.FS, options ...Option) (Engine, error) {
 		return nil, fmt.Errorf("error creating new engine: %w", err)
 	}
 
-	m := moldEngine{}
+	m, err := build(c)
+	if err != nil {
+		return nil, err
+	}
+	if c.watch.val {
+		if watchable(fsys) {
+			m.watch(c.watchInterval.val)
+		} else {
+			c.logger.val.Debug("filesystem not watched, not a directory of the operating system")
+		}
+	}
+	return m, nil
+}
+
+// build parses all templates and assembles the views with the configuration.
+func build(c Config) (*moldEngine, error) {
+	m := &moldEngine{
+		c:        c,
+		stacks:   map[string]*templateFile{},
+		views:    newViewCache(c.viewCache.val),
+		variants: newLRUCache[variant, *compiledView](c.viewCache.val, 0),
+	}
+	if c.bufferPool.set {
+		m.buffers = newBufferPool(c.bufferPool.val)
+	}
+	if c.partialCache.set {
+		m.partials = newLRUCache[partialKey, template.HTML](c.partialCache.val.size, c.partialCache.val.ttl)
+	}
+
+	// traverse to fetch all templates and in-memory partials
+	set, layoutFiles, err := walk(&c)
+	if err != nil {
+		return nil, fmt.Errorf("error creating new engine: %w", err)
+	}
+
+	// process layout
+	layout, err := parseLayout(&c, set)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing layout: %w", err)
+	}
+
+	if c.standalone.set {
+		if m.bare, err = parseLayoutFile(&c, set, standaloneLayoutName, "{{render}}"); err != nil {
+			return nil, fmt.Errorf("error parsing layout: %w", err)
+		}
+	}
+
+	m.set = set
+	m.layout = layout
+	m.layoutFiles = layoutFiles
+
+	// process views
+	var errs []error
+	seen := map[string]bool{}
+	empty := map[string][]string{}
+	for _, name := range sortedKeys(set) {
+		if set[name].markdown {
+			continue
+		}
+		view, err := m.assemble(set, name)
+		if errors.Is(err, errPartialOnly) {
+			continue
+		}
+		if err != nil {
+			// a broken partial would be reported for every view referencing it
+			if !seen[err.Error()] {
+				seen[err.Error()] = true
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if len(view.emptySections) > 0 {
+			empty[name] = view.emptySections
+		}
+		m.views.add(name, view)
+	}
+	// templates rendered as partials are not expected to define sections,
+	// the references are known once all views are assembled
+	partials := partialRefs(layout, set)
+	for _, name := range sortedKeys(empty) {
+		if !partials[name] {
+			errs = append(errs, fmt.Errorf("error parsing view '%s': sections not defined: %s", name, strings.Join(empty[name], ", ")))
+		}
+	}
+	for _, view := range sortedKeys(c.viewFuncMaps) {
+		if t, ok := set[view]; !ok || t.markdown {
+			errs = append(errs, fmt.Errorf("error configuring functions of view '%s': %w", view, ErrNotFound))
+		}
+	}
+	for _, alias := range sortedKeys(c.aliases) {
+		target := c.aliases[alias]
+		if t, ok := set[alias]; ok && !t.markdown {
+			errs = append(errs, fmt.Errorf("error registering alias '%s': conflicts with an existing view", alias))
+		}
+		if t, ok := set[target]; !ok || t.markdown {
+			errs = append(errs, fmt.Errorf("error registering alias '%s': view '%s': %w", alias, target, ErrNotFound))
+		}
+	}
+	if c.caseFold.val {
+		folded, foldErrs := foldNames(set, c.aliases)
+		m.folded = folded
+		errs = append(errs, foldErrs...)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// foldNames returns the views and aliases by their lowercase paths, see [WithCaseInsensitiveLookup].
+// Views differing only by case are reported.
+func foldNames(set templateSet, aliases map[string]string) (map[string]string, []error) {
+	var errs []error
+	folded := map[string]string{}
+	for _, name := range slices.Concat(sortedKeys(set), sortedKeys(aliases)) {
+		if t, ok := set[name]; ok && t.markdown {
+			continue
+		}
+		key := strings.ToLower(name)
+		if prev, ok := folded[key]; ok && prev != name {
+			errs = append(errs, fmt.Errorf("error enabling case-insensitive lookup: views '%s' and '%s' differ only by case", prev, name))
+			continue
+		}
+		folded[key] = name
+	}
+	return folded, errs
+}
+
+// Validate implements Engine.
+func (m *moldEngine) Validate() error {
+	_, err := build(m.c)
+	return err
+}
+
+// Close implements Engine.
+func (m *moldEngine) Close() error {
+	if m.watcher != nil {
+		m.watcher.once.Do(func() {
+			close(m.watcher.done)
+			<-m.watcher.stopped
+		})
+	}
+
+	m.views.purge()
+	m.variants.purge()
+	if m.partials != nil {
+		m.partials.purge()
+	}
+	return nil
+}
+
+// assemble merges the view with the layout, its sections and partials.
+func (m *moldEngine) assemble(set templateSet, name string) (*compiledView, error) {
+	return m.assembleVariant(set, name, assembly{})
+}
+
+// assembleVariant is like assemble but with the layouts and partials of the assembly.
+func (m *moldEngine) assembleVariant(set templateSet, name string, a assembly) (*compiledView, error) {
+	if callsFuncs(set[name], m.c.partialFuncMap.val) {
+		return nil, fmt.Errorf("error parsing view '%s': %w", name, errPartialOnly)
+	}
+	// a template calling the functions of another view is a partial of that view
+	otherFuncs := otherViewFuncs(&m.c, name)
+	if callsFuncs(set[name], otherFuncs) {
+		return nil, fmt.Errorf("error parsing view '%s': %w", name, errPartialOnly)
+	}
+
+	layout := m.layout
+	stack, err := layoutStack(set[name])
+	if err != nil {
+		return nil, fmt.Errorf("error parsing view '%s': %w", name, err)
+	}
+	if a.layouts != nil {
+		stack = a.layouts
+	}
+	if len(stack) == 0 && m.standalone(name) {
+		layout = m.bare
+	}
+	if len(stack) > 0 {
+		// layouts are parsed once per build, the view clones them
+		key := strings.Join(stack, ",")
+		if layout = m.stacks[key]; layout == nil {
+			if layout, err = stackLayouts(&m.c, set, stack); err != nil {
+				return nil, fmt.Errorf("error parsing view '%s': %w", name, err)
+			}
+			m.stacks[key] = layout
+		}
+	}
+
+	view, err := parseView(set, layout, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := overridePartials(set, view, a.overrides); err != nil {
+		return nil, fmt.Errorf("error parsing view '%s': %w", name, err)
+	}
+	// overrides may reference the partials they replace
+	if cycle := templateCycle(view, view.Name()); cycle != nil {
+		return nil, fmt.Errorf("error parsing view '%s': cyclic reference: %s", name, strings.Join(cycle, " -> "))
+	}
+
+	if called := calledFuncs(view, otherFuncs); len(called) > 0 {
+		return nil, fmt.Errorf("error parsing view '%s': functions not available in the view: %s", name, strings.Join(called, ", "))
+	}
+
+	if m.c.strictSections.val {
+		if unused := unusedSections(view, set[name], m.c.baseTemplate.val); len(unused) > 0 {
+			return nil, fmt.Errorf("error parsing view '%s': sections not rendered: %s", name, strings.Join(unused, ", "))
+		}
+	}
+
+	if !m.c.autoHead.val && slices.Contains(emptySections(view, set[name], layout), m.c.headSection.val) {
+		return nil, fmt.Errorf("error parsing view '%s': head section '%s' not defined", name, m.c.headSection.val)
+	}
+
+	m.c.logger.val.Debug("assembled view", "view", name, "partials", partialNames(set[name].refs))
+	v := compile(&m.c, view)
+	v.overrides = a.overrides.key()
+	// the sections of the default layout are optional, as is the head section left empty by auto head
+	if m.c.strictSections.val && layout.path != DefaultLayoutName {
+		v.emptySections = emptySections(view, set[name], layout)
+		if m.c.autoHead.val {
+			v.emptySections = slices.DeleteFunc(v.emptySections, func(s string) bool { return s == m.c.headSection.val })
+		}
+	}
+	v.funcs(m.c.viewFuncMaps[name])
+	if m.c.markdown.set {
+		v.funcs(markdownFuncs(set, m.c.markdown.val))
+	}
+	m.bindFuncs(v)
+	v.scoped = len(calledFuncs(view, m.c.renderFuncs)) > 0
+	return v, nil
+}
+
+// acquire returns a copy of the view depending on the render, bound to the per-render values
+// and the functions created by the factory, see [WithFuncMapFactory].
+// Copies are reused by later renders once released, the view itself is never executed.
+func (m *moldEngine) acquire(v *compiledView, view string, data any, values map[string]any) *compiledView {
+	c, _ := v.copies.Get().(*compiledView)
+	if c == nil {
+		c = m.copyView(v)
+	}
+	// without values, the functions are those declared for parsing
+	if values != nil {
+		c.scope.funcs = renderFuncs(&m.c, values)
+	}
+	if m.c.funcMapFactory.set {
+		if c.scope.funcs == nil {
+			c.scope.funcs = template.FuncMap{}
+		}
+		for k, f := range m.c.funcMapFactory.val(*renderContext(view, data, values)) {
+			c.scope.funcs[k] = f
+		}
+	}
+	return c
+}
+
+// release returns the copy of the view to be reused, once executed.
+func (v *compiledView) release(c *compiledView) {
+	c.scope.funcs = nil
+	v.copies.Put(c)
+}
+
+// copyView returns a copy of the view with the functions depending on the render
+// bound to the scope of the copy, see [moldEngine.acquire].
+func (m *moldEngine) copyView(v *compiledView) *compiledView {
+	c := &compiledView{overrides: v.overrides, scope: &renderScope{}}
+	if t, ok := v.exec.(*texttemplate.Template); ok {
+		c.tmpl, c.exec = v.tmpl, texttemplate.Must(t.Clone()) // safe, text templates can always be cloned
+	} else {
+		t := template.Must(v.tmpl.Clone()) // safe, the view is never executed
+		c.tmpl, c.exec = t, t
+	}
+
+	funcs := template.FuncMap{
+		cachedPartialExecFunc: func(name string, key, data any) (template.HTML, error) {
+			return m.cachedPartial(c, name, key, data)
+		},
+	}
+	for name, f := range m.c.renderFuncs {
+		funcs[name] = c.scope.dispatch(name, f)
+	}
+	// the html/template of text mode is shared by all copies, it is only used to look up sections
+	if t, ok := c.exec.(*texttemplate.Template); ok {
+		t.Funcs(funcs)
+	} else {
+		c.tmpl.Funcs(funcs)
+	}
+	return c
+}
+
+// renderScope holds the functions of the current render of a copy of a view.
+type renderScope struct {
+	funcs template.FuncMap
+}
+
+// dispatch returns a function of the same type as f, calling the function of the current render with the name,
+// or f if the render has none, i.e. the function declared for parsing.
+func (s *renderScope) dispatch(name string, f any) any {
+	typ := reflect.TypeOf(f)
+	return reflect.MakeFunc(typ, func(args []reflect.Value) []reflect.Value {
+		fn := reflect.ValueOf(f)
+		if g, ok := s.funcs[name]; ok {
+			fn = reflect.ValueOf(g)
+		}
+		if fn.Type() != typ {
+			// recovered by the template, failing the render
+			panic(fmt.Errorf("function %q of the render is of type %s, declared as %s", name, fn.Type(), typ))
+		}
+		if typ.IsVariadic() {
+			return fn.CallSlice(args)
+		}
+		return fn.Call(args)
+	}).Interface()
+}
+
+// bindFuncs binds the functions executing templates within the assembled view.
+func (m *moldEngine) bindFuncs(v *compiledView) {
+	v.funcs(template.FuncMap{
+		cachedPartialExecFunc: func(name string, key, data any) (template.HTML, error) {
+			return m.cachedPartial(v, name, key, data)
+		},
+	})
+}
+
+// renderContext returns the context of the render of the view, see [WithFuncMapFactory].
+func renderContext(view string, data any, values map[string]any) *RenderContext {
+	rc := &RenderContext{View: view, Data: data, Values: values, Context: context.Background()}
+	if r, ok := values["request"].(*http.Request); ok && r != nil {
+		rc.Request, rc.Context = r, r.Context()
+	}
+	return rc
+}
+
+// funcs adds the functions to the assembled view.
+func (v *compiledView) funcs(funcs template.FuncMap) {
+	if len(funcs) == 0 {
+		return
+	}
+	v.tmpl.Funcs(funcs)
+	if t, ok := v.exec.(*texttemplate.Template); ok {
+		t.Funcs(funcs)
+	}
+}
+
+// otherViewFuncs returns the functions configured with [WithViewFuncMap] for views other than the view,
+// excluding the functions of the view with the same name.
+func otherViewFuncs(c *Config, view string) map[string]any {
+	funcs := map[string]any{}
+	for v, funcMap := range c.viewFuncMaps {
+		if v == view {
+			continue
+		}
+		for k, f := range funcMap {
+			if _, ok := c.viewFuncMaps[view][k]; !ok {
+				funcs[k] = f
+			}
+		}
+	}
+	return funcs
+}
+
+// calledFuncs returns the sorted names of the functions in funcMap called by any template of the view.
+func calledFuncs(view *template.Template, funcMap map[string]any) (names []string) {
+	if len(funcMap) == 0 {
+		return nil
+	}
+	for _, t := range view.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		funcIdents(t.Tree.Root, false, func(ident *parse.IdentifierNode, _ bool) {
+			if _, ok := funcMap[ident.Ident]; ok && !slices.Contains(names, ident.Ident) {
+				names = append(names, ident.Ident)
+			}
+		})
+	}
+	sort.Strings(names)
+	return names
+}
+
+type partialKey struct {
+	name      string
+	key       any
+	overrides string // partials differ between variants, see [Overrides.key]
+}
+
+// cachedPartial renders the partial within the view, memoized by the key if partials are cached.
+func (m *moldEngine) cachedPartial(v *compiledView, name string, key, data any) (template.HTML, error) {
+	if key != nil && !reflect.ValueOf(key).Comparable() {
+		return "", fmt.Errorf("error rendering partial '%s': cache key of type %T is not comparable", name, key)
+	}
+	k := partialKey{name: name, key: key, overrides: v.overrides}
+	if m.partials != nil {
+		if out, ok := m.partials.get(k); ok {
+			return out, nil
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := v.exec.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", err
+	}
+	out := template.HTML(buf.String())
+	if m.partials != nil {
+		m.partials.add(k, out)
+	}
+	return out, nil
+}
+
+// partialNames returns the names of the referenced partials.
+func partialNames(refs []nestedFile) (names []string) {
+	for _, ref := range refs {
+		if ref.typ == partialFunc {
+			names = append(names, ref.name)
+		}
+	}
+	return names
+}
+
+// target returns the name of the view the alias refers to, or the view itself if it is not an alias.
+// Views are matched regardless of case if configured, see [WithCaseInsensitiveLookup].
+func (m *moldEngine) target(view string) string {
+	if m.c.caseFold.val {
+		m.mu.RLock()
+		if name, ok := m.folded[strings.ToLower(view)]; ok {
+			view = name
+		}
+		m.mu.RUnlock()
+	}
+	if target, ok := m.c.aliases[view]; ok {
+		return target
+	}
+	return view
+}
+
+// lookup returns the assembled template for the view.
+// Views evicted from the cache are assembled again.
+func (m *moldEngine) lookup(view string) (*compiledView, error) {
+	view = m.target(view)
+	if v, ok := m.views.get(view); ok {
+		return v, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if v, ok := m.views.get(view); ok {
+		return v, nil
+	}
+	if t, ok := m.set[view]; !ok || t.markdown {
+		return nil, m.notFoundErr(view)
+	}
+
+	v, err := m.assemble(m.set, view)
+	if err != nil {
+		return nil, err
+	}
+	m.views.add(view, v)
+	return v, nil
+}
+
+// lookupVariant is like lookup but returns the view assembled with the layouts and partials of the assembly.
+func (m *moldEngine) lookupVariant(view string, a assembly) (*compiledView, error) {
+	key := variant{view: m.target(view), layout: strings.Join(a.layouts, ","), overrides: a.overrides.key()}
+	if v, ok := m.variants.get(key); ok {
+		return v, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if v, ok := m.variants.get(key); ok {
+		return v, nil
+	}
+	if t, ok := m.set[key.view]; !ok || t.markdown {
+		return nil, m.notFoundErr(key.view)
+	}
+
+	v, err := m.assembleVariant(m.set, key.view, a)
+	if err != nil {
+		return nil, err
+	}
+	m.variants.add(key, v)
+	return v, nil
+}
+
+// standalone reports whether the view is rendered without a layout, see [WithStandaloneViews].
+func (m *moldEngine) standalone(view string) bool {
+	return m.c.standalone.set && matchAny(m.c.standalone.val, view)
+}
+
+// notFoundErr returns [ErrNotFound] for the view, with a hint if the view was skipped as a layout file.
+func (m *moldEngine) notFoundErr(view string) error {
+	if m.layoutFiles[view] {
+		return fmt.Errorf("view '%s' looks like a layout file, layout files are not renderable as views: %w", view, ErrNotFound)
+	}
+	return ErrNotFound
+}
+
+// Render implements Layout.
+func (m *moldEngine) Render(w io.Writer, view string, data any) error {
+	return m.renderSection(w, view, "", data, nil)
+}
+
+// RenderWith implements Engine.
+func (m *moldEngine) RenderWith(w io.Writer, view string, data any, values map[string]any) error {
+	if values == nil {
+		values = map[string]any{}
+	}
+	return m.renderSection(w, view, "", data, values)
+}
+
+// RenderWithLayout implements Engine.
+func (m *moldEngine) RenderWithLayout(w io.Writer, layout, view string, data any) error {
+	return m.renderVariant(w, view, data, assembly{layouts: []string{layout}})
+}
+
+// RenderWithOverrides implements Engine.
+func (m *moldEngine) RenderWithOverrides(w io.Writer, view string, data any, overrides Overrides) error {
+	if len(overrides) == 0 {
+		return m.Render(w, view, data)
+	}
+	return m.renderVariant(w, view, data, assembly{overrides: overrides})
+}
+
+// renderVariant renders the view assembled with the layouts and partials of the assembly.
+func (m *moldEngine) renderVariant(w io.Writer, view string, data any, a assembly) error {
+	return m.observe(view, func() error {
+		if err := m.watchErr(); err != nil {
+			return fmt.Errorf("error rendering '%s': %w", view, err)
+		}
+		v, err := m.lookupVariant(view, a)
+		if errors.Is(err, ErrNotFound) && m.c.notFoundView.set {
+			v, err = m.lookupVariant(m.c.notFoundView.val, a)
+		}
+		if err != nil {
+			return err
+		}
+		return m.execute(w, view, v, "", data, nil)
+	})
+}
+
+// RenderSection implements Engine.
+func (m *moldEngine) RenderSection(w io.Writer, view, section string, data any) error {
+	if section == "" {
+		return fmt.Errorf("error rendering '%s': section not specified", view)
+	}
+	return m.renderSection(w, view, section, data, nil)
+}
+
+// renderSection renders the section of the view, or the complete view if section is empty.
+// The per-render values are bound to a copy of the view, unless nil.
+func (m *moldEngine) renderSection(w io.Writer, view, section string, data any, values map[string]any) error {
+	return m.observe(view, func() error { return m.render(w, view, section, data, values) })
+}
+
+// observe calls render, reporting the duration and error of the render of the view
+// to the logger and the render hook.
+func (m *moldEngine) observe(view string, render func() error) error {
+	start := time.Now()
+	err := render()
+	duration := time.Since(start)
+
+	m.c.logger.val.Debug("rendered view", "view", view, "duration", duration, "error", err)
+	if m.c.renderHook.set {
+		m.c.renderHook.val(view, duration, err)
+	}
+	return err
+}
+
+func (m *moldEngine) render(w io.Writer, view, section string, data any, values map[string]any) error {
+	if err := m.watchErr(); err != nil {
+		return fmt.Errorf("error rendering '%s': %w", view, err)
+	}
+	layout, err := m.lookup(view)
+	if errors.Is(err, ErrNotFound) && m.c.notFoundView.set && section == "" {
+		layout, err = m.lookup(m.c.notFoundView.val)
+	}
+	if err != nil {
+		return err
+	}
+	return m.execute(w, view, layout, section, data, values)
+}
+
+// execute executes the section of the assembled view, or the complete view if section is empty.
+// Views depending on the render are executed by a copy bound to the per-render values, see [moldEngine.acquire].
+func (m *moldEngine) execute(w io.Writer, view string, layout *compiledView, section string, data any, values map[string]any) error {
+	if section != "" {
+		if t := layout.tmpl.Lookup(section); t == nil || t.Tree == nil {
+			return fmt.Errorf("error rendering section '%s' of '%s': %w", section, view, ErrNotFound)
+		}
+	}
+	execute := func(w io.Writer, data any) error {
+		exec := layout.exec
+		if layout.scoped {
+			c := m.acquire(layout, view, data, values)
+			// released once executed, even if abandoned on timeout
+			defer layout.release(c)
+			exec = c.exec
+		}
+		if section != "" {
+			return exec.ExecuteTemplate(w, section, data)
+		}
+		return exec.Execute(w, data)
+	}
+
+	data = mergeGlobals(m.c.globals.val, data)
+	if m.c.renderTimeout.val > 0 {
+		execute = withTimeout(execute, m.c.renderTimeout.val)
+	}
+
+	if !m.c.stripComments.val && !m.c.minify.val {
+		if err := execute(w, data); err != nil {
+			return fmt.Errorf("error rendering '%s': %w", view, err)
+		}
+		return nil
+	}
+
+	// post-processing requires the complete output
+	buf := m.buffers.get()
+	defer m.buffers.put(buf)
+	if err := execute(buf, data); err != nil {
+		return fmt.Errorf("error rendering '%s': %w", view, err)
+	}
+	out := buf.Bytes()
+	if m.c.stripComments.val {
+		out = stripComments(out)
+	}
+	if m.c.minify.val {
+		var err error
+		if out, err = m.c.minifier.val(out); err != nil {
+			return fmt.Errorf("error minifying '%s': %w", view, err)
+		}
+	}
+	_, err := w.Write(out)
+	return err
+}
+
+// withTimeout returns execute bounded by the timeout, see [WithRenderTimeout].
+func withTimeout(execute func(io.Writer, any) error, timeout time.Duration) func(io.Writer, any) error {
+	return func(w io.Writer, data any) error {
+		cw := &cancelWriter{}
+		done := make(chan error, 1)
+		go func() { done <- execute(cw, data) }()
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				return err
+			}
+			_, err = cw.buf.WriteTo(w)
+			return err
+		case <-timer.C:
+			cw.cancel()
+			return fmt.Errorf("timed out after %s: %w", timeout, context.DeadlineExceeded)
+		}
+	}
+}
+
+// errCancelled is returned by writes of abandoned renders, stopping their execution.
+var errCancelled = errors.New("render cancelled")
+
+// cancelWriter buffers the output of a render, failing all writes once cancelled.
+type cancelWriter struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	cancelled bool
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.cancelled {
+		return 0, errCancelled
+	}
+	return w.buf.Write(p)
+}
+
+func (w *cancelWriter) cancel() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.cancelled = true
+}
+
+// readerLayoutName is the name of the layout configured with [WithLayoutString] or [WithLayoutReader].
+const readerLayoutName = "<layout>"
+
+// standaloneLayoutName is the name of the layout of standalone views, see [WithStandaloneViews].
+const standaloneLayoutName = "<standalone>"
+
+// textViewName is the name of the views rendered with [Engine.RenderText].
+// It cannot be referenced by other templates, as it is not a valid path.
+const textViewName = "<text>"
+
+// RenderText implements Engine.
+func (m *moldEngine) RenderText(w io.Writer, body string, data any) error {
+	return m.observe(textViewName, func() error {
+		if err := m.watchErr(); err != nil {
+			return fmt.Errorf("error rendering '%s': %w", textViewName, err)
+		}
+		v, err := m.assembleText(body)
+		if err != nil {
+			return err
+		}
+		return m.execute(w, textViewName, v, "", data, nil)
+	})
+}
+
+// assembleText assembles the template body as a view, without adding it to the engine.
+func (m *moldEngine) assembleText(body string) (*compiledView, error) {
+	t, err := parseFile(&m.c, textViewName, body)
+	if err != nil {
+		return nil, err
+	}
+	if m.c.strictFuncs.val {
+		if err := errors.Join(checkFuncs(t, m.c.funcMap.val)...); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	set := maps.Clone(m.set)
+	set[textViewName] = t
+	return m.assemble(set, textViewName)
+}
+
+// notFound reports whether the view does not exist and the fallback view configured with
+// [WithNotFoundView] is rendered in its place.
+func (m *moldEngine) notFound(view string) bool {
+	if !m.c.notFoundView.set {
+		return false
+	}
+	_, err := m.lookup(view)
+	return errors.Is(err, ErrNotFound)
+}
+
+// DebugTree implements Engine.
+func (m *moldEngine) DebugTree(view string) (string, error) {
+	// the trees of executed views are rewritten by escaping, a copy is assembled instead
+	layout, err := m.Template(view)
+	if err != nil {
+		return "", err
+	}
+
+	var names []string
+	for _, t := range layout.Templates() {
+		if t.Name() != layout.Name() && t.Tree != nil {
+			names = append(names, t.Name())
+		}
+	}
+	sort.Strings(names)
+	names = append([]string{layout.Name()}, names...)
+
+	var b strings.Builder
+	for _, name := range names {
+		t := layout.Lookup(name)
+		fmt.Fprintf(&b, "{{define %q}}%s{{end}}\n", name, t.Tree.Root)
+	}
+
+	return b.String(), nil
+}
+
+// Template implements Engine.
+func (m *moldEngine) Template(view string) (*template.Template, error) {
+	view = m.target(view)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.set[view]; !ok || t.markdown {
+		return nil, m.notFoundErr(view)
+	}
+
+	// a template cannot be cloned once executed, the view is assembled afresh instead.
+	v, err := m.assemble(m.set, view)
+	if err != nil {
+		return nil, err
+	}
+	return v.tmpl, nil
+}
+
+// RenderAll implements Engine.
+func (m *moldEngine) RenderAll(dir string, dataFor func(view string) any) error {
+	var errs []error
+	for _, view := range m.viewNames() {
+		var data any
+		if dataFor != nil {
+			data = dataFor(view)
+		}
+		if err := m.renderFile(filepath.Join(dir, filepath.FromSlash(view)), view, data); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *moldEngine) renderFile(name, view string, data any) error {
+	var buf bytes.Buffer
+	if err := m.Render(&buf, view, data); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return fmt.Errorf("error rendering '%s': %w", view, err)
+	}
+	if err := os.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("error rendering '%s': %w", view, err)
+	}
+	return nil
+}
+
+// viewNames returns the sorted names of the templates renderable as views,
+// excluding the templates referenced as partials.
+func (m *moldEngine) viewNames() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	partials := partialRefs(m.layout, m.set)
+	var names []string
+	for _, name := range sortedKeys(m.set) {
+		t := m.set[name]
+		if partials[name] || t.markdown || callsFuncs(t, m.c.partialFuncMap.val) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// Has implements Engine.
+func (m *moldEngine) Has(view string) bool {
+	view = m.target(view)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.set[view]
+	return ok && !t.markdown && !callsFuncs(t, m.c.partialFuncMap.val) && !callsFuncs(t, otherViewFuncs(&m.c, view))
+}
+
+// Views implements Engine.
+func (m *moldEngine) Views() []string {
+	return m.viewNames()
+}
+
+// partialRefs returns the names of the templates referenced as partials by the layout or any template of the set.
+func partialRefs(layout *templateFile, set templateSet) map[string]bool {
+	partials := map[string]bool{}
+	for _, t := range append([]*templateFile{layout}, slices.Collect(maps.Values(set))...) {
+		for _, ref := range t.refs {
+			if ref.typ == partialFunc {
+				partials[ref.name] = true
+			}
+		}
+	}
+	return partials
+}
+
+// Dependencies implements Engine.
+func (m *moldEngine) Dependencies(view string) ([]string, error) {
+	view = m.target(view)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.set[view]
+	if !ok || t.markdown {
+		return nil, m.notFoundErr(view)
+	}
+
+	refs := append(slices.Clone(m.layout.refs), t.refs...)
+	seen := map[string]bool{}
+	for len(refs) > 0 {
+		ref := refs[0]
+		refs = refs[1:]
+		if ref.typ != partialFunc || seen[ref.name] {
+			continue
+		}
+		seen[ref.name] = true
+		if t := m.set[ref.name]; t != nil {
+			refs = append(refs, t.refs...)
+		}
+	}
+
+	return sortedKeys(seen), nil
+}
+
+// Graph implements Engine.
+func (m *moldEngine) Graph() (map[string][]string, error) {
+	views := m.viewNames()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	graph := make(map[string][]string, len(views))
+	for _, view := range views {
+		t := m.set[view]
+		stack, err := layoutStack(t)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing view '%s': %w", view, err)
+		}
+		if len(stack) == 0 && !m.standalone(view) {
+			stack = []string{m.layout.path}
+		}
+
+		var entries []string
+		for _, layout := range stack {
+			entries = append(entries, "layout:"+layout)
+		}
+		partials := partialNames(t.refs)
+		slices.Sort(partials)
+		for _, partial := range slices.Compact(partials) {
+			entries = append(entries, "partial:"+partial)
+		}
+		for _, name := range definedTemplates(t) {
+			entries = append(entries, "section:"+name)
+		}
+		graph[view] = entries
+	}
+	return graph, nil
+}
+
+// Stats implements Engine.
+func (m *moldEngine) Stats() Stats {
+	views := m.viewNames()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	layouts := map[string]bool{m.layout.path: true}
+	for _, view := range views {
+		stack, _ := layoutStack(m.set[view]) // validated by New
+		for _, layout := range stack {
+			layouts[layout] = true
+		}
+	}
+
+	s := Stats{Layouts: len(layouts), Views: len(views), Partials: len(m.set) - len(views), Bytes: int64(len(m.layout.source))}
+	for _, t := range m.set {
+		s.Bytes += int64(len(t.source))
+	}
+	return s
+}
+
+// Source implements Engine.
+func (m *moldEngine) Source(name string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if name == m.layout.path {
+		return m.layout.source, true
+	}
+	if t, ok := m.set[name]; ok {
+		return t.source, true
+	}
+	return "", false
+}
+
+// HideFS implements Engine.
+func (m *moldEngine) HideFS() fs.FS {
+	exts := slices.Clone(m.c.exts.val)
+	if m.c.markdown.set {
+		exts = append(exts, markdownExt)
+	}
+	return HideFS(m.c.fs, exts...)
+}
+
+// Reload implements Engine.
+func (m *moldEngine) Reload(view string) error {
+	view = m.target(view)
+	if matchExt(m.c.exts.val, view) == "" || validateLayoutFile(m.c.exts.val, view) == nil || m.c.filtered(view) {
+		return fmt.Errorf("error reloading view '%s': %w", view, ErrNotFound)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	set := make(templateSet, len(m.set))
+	for name, t := range m.set {
+		set[name] = t
+	}
+
+	body, err := m.readTemplate(view)
+	if err != nil {
+		return fmt.Errorf("error reloading view '%s': %w", view, err)
+	}
+	body.typ = viewType
+	set[view] = body
+
+	// re-read the partials referenced by the view, transitively
+	refs, err := processTree(body, set)
+	if err != nil {
+		return fmt.Errorf("error reloading view '%s': %w", view, err)
+	}
+	seen := map[string]bool{view: true}
+	for len(refs) > 0 {
+		ref := refs[0]
+		refs = refs[1:]
+		if seen[ref.name] {
+			continue
+		}
+		seen[ref.name] = true
+		if _, ok := m.c.partials[ref.name]; ok {
+			continue
+		}
+		if t := set[ref.name]; t != nil && t.resolved {
+			continue
+		}
+		if m.c.filtered(ref.name) {
+			return fmt.Errorf("error reloading partial '%s': %w", ref.name, ErrNotFound)
+		}
+
+		t, err := m.readTemplate(ref.name)
+		if err != nil {
+			return fmt.Errorf("error reloading partial '%s': %w", ref.name, err)
+		}
+		t.typ = partialType
+		set[ref.name] = t
+
+		nested, err := processTree(t, set)
+		if err != nil {
+			return fmt.Errorf("error reloading partial '%s': %w", ref.name, err)
+		}
+		refs = append(refs, nested...)
+	}
+
+	t, err := m.assemble(set, view)
+	if err != nil {
+		return err
+	}
+	// the view may be new, or differ only by case from another
+	folded := m.folded
+	if m.c.caseFold.val {
+		var errs []error
+		if folded, errs = foldNames(set, m.c.aliases); len(errs) > 0 {
+			return fmt.Errorf("error reloading view '%s': %w", view, errors.Join(errs...))
+		}
+	}
+
+	m.set, m.folded = set, folded
+	m.views.add(view, t)
+	m.variants.purge()
+	if m.partials != nil {
+		m.partials.purge()
+	}
+	return nil
+}
+
+// readTemplate reads and parses the template file from the filesystem, checked as by [New].
+func (m *moldEngine) readTemplate(name string) (*templateFile, error) {
+	r := readTemplateFile(&m.c, name)
+	return r.t, errors.Join(r.errs...)
+}
+
+// walk parses the template files in the filesystem and the in-memory partials.
+// It also returns the paths of the layout files skipped, which are not views.
+func walk(c *Config) (templateSet, map[string]bool, error) {
+	fsys, exts := c.fs, c.exts.val
+	var paths []string
+	layouts := map[string]bool{}
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// skip hidden files and directories, unless included.
+		// the root is named after the directory configured with WithRoot, which may be hidden itself.
+		if !c.includeHidden.val && strings.HasPrefix(d.Name(), ".") && path != "." {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if matchAny(c.exclude.val, path) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		if c.include.set && !matchAny(c.include.val, path) {
+			return nil
+		}
+
+		if matchExt(exts, d.Name()) == "" && !isMarkdown(c, path) {
+			return nil
+		}
+
+		// skip layout files, recorded to explain why they are not found as views
+		if err := validateLayoutFile(exts, path); err == nil {
+			layouts[path] = true
+			return nil
+		}
+
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// an empty root is most likely a typo in its path
+	if c.root.set && len(paths) == 0 {
+		return nil, nil, fmt.Errorf("root '%s' contains no template files", c.root.val)
+	}
+
+	// files are parsed concurrently, results are collected in the walk order
+	// for the errors to be reported deterministically.
+	results := make([]parseResult, len(paths))
+	parallel(len(paths), c.parseWorkers.val, func(i int) {
+		results[i] = readTemplateFile(c, paths[i])
+	})
+
+	set := templateSet{}
+	duplicates := duplicateDetector{policy: c.duplicatePolicy.val, logger: c.logger.val, paths: map[string]string{}}
+	var errs []error
+	for i, path := range paths {
+		// errors are collected to report all invalid files at once
+		if len(results[i].errs) > 0 {
+			errs = append(errs, results[i].errs...)
+			continue
+		}
+		if err := duplicates.add(set, path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		set[path] = results[i].t
+		c.logger.val.Debug("parsed template", "path", path)
+	}
+
+	// in-memory partials
+	for _, name := range sortedKeys(c.partials) {
+		t, err := parseFile(c, name, c.partials[name])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if c.strictFuncs.val {
+			errs = append(errs, checkFuncs(t, c.funcMap.val)...)
+		}
+		set[name] = t
+	}
+
+	return set, layouts, errors.Join(errs...)
+}
+
+type parseResult struct {
+	t    *templateFile
+	errs []error
+}
+
+// readTemplateFile reads and parses the template file at path.
+// It is safe to call concurrently.
+func readTemplateFile(c *Config, path string) parseResult {
+	f, err := readFile(c.fs, path, c.maxFileSize.val)
+	if err != nil {
+		return parseResult{errs: []error{fmt.Errorf("error reading template '%s': %w", path, err)}}
+	}
+	t, err := parseFile(c, path, f)
+	if err != nil {
+		return parseResult{errs: []error{err}}
+	}
+	if c.strictFuncs.val {
+		if errs := checkFuncs(t, c.funcMap.val); len(errs) > 0 {
+			return parseResult{errs: errs}
+		}
+	}
+	return parseResult{t: t}
+}
+
+// parallel calls fn for each index in [0, n) with at most workers concurrent calls.
+func parallel(n, workers int, fn func(i int)) {
+	if workers <= 1 || n <= 1 {
+		for i := range n {
+			fn(i)
+		}
+		return
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(workers, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := range n {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// duplicateDetector detects template paths that differ only in case, which collide on
+// case-insensitive filesystems, and handles them according to the policy.
+type duplicateDetector struct {
+	policy DuplicatePolicy
+	logger *slog.Logger
+	paths  map[string]string // lowercased path to path
+}
+
+// add registers the path, reporting an error if it is a duplicate and the policy is [DuplicateError].
+// With [DuplicateLastWins], the previously registered path is removed from the set.
+func (d duplicateDetector) add(set templateSet, path string) error {
+	key := strings.ToLower(path)
+	prev, ok := d.paths[key]
+	d.paths[key] = path
+	if !ok {
+		return nil
+	}
+
+	switch d.policy {
+	case DuplicateError:
+		return fmt.Errorf("error parsing template '%s': duplicate of '%s'", path, prev)
+	case DuplicateLastWins:
+		delete(set, prev)
+	default:
+		d.logger.Warn("duplicate template", "path", path, "duplicate", prev)
+	}
+	return nil
+}
+
+func parseFile(c *Config, name, source string) (*templateFile, error) {
+	left, right := c.delims()
+	body, edits := rewriteKeywordArgs(source, left, right)
+	t, err := c.newTemplate(name).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template '%s': %w", name, newParseError(name, source, left, c.errorSnippets.val, err))
+	}
+
+	f := &templateFile{
+		Template:    t,
+		body:        body,
+		source:      source,
+		edits:       edits,
+		leftDelim:   left,
+		path:        name,
+		contentType: parseContentType(body),
+
+		componentDir:   c.componentDir.val,
+		sourceComments: c.sourceComments.val,
+		errorSnippets:  c.errorSnippets.val,
+		resolve:        partialResolver(c),
+	}
+	if expects := parseExpects(body); c.strictArgs.val && len(expects) > 0 {
+		if err := errors.Join(checkExpects(f, expects)...); err != nil {
+			return nil, err
+		}
+		insertExpects(f, expects)
+	}
+	if isMarkdown(c, name) {
+		f.markdown = true
+		f.markdownTmpl = template.Must(t.Clone()) // safe, not executed yet
+	}
+	return f, nil
+}
+
+func setup(c *Config, options ...Option) error {
+	// apply options
+	for _, opt := range options {
+		opt(c)
+	}
+
+	// filesystem
+	if c.fs == nil {
+		return errors.New("filesystem not specified")
+	}
+
+	// root
+	if c.root.set {
+		info, err := fs.Stat(c.fs, c.root.val)
+		if err != nil {
+			return fmt.Errorf("error setting subdirectory '%s': %w", c.root.val, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("root '%s' is not a directory", c.root.val)
+		}
+		sub, err := fs.Sub(c.fs, c.root.val)
+		if err != nil {
+			return fmt.Errorf("error setting subdirectory '%s': %w", c.root.val, err)
+		}
+		c.fs = sub
+	}
+
+	// themes
+	if c.themes.set {
+		if len(c.themes.val) == 0 {
+			return errors.New("themes not specified")
+		}
+		themes, err := newThemeFS(c.fs, c.themes.val)
+		if err != nil {
+			return err
+		}
+		c.fs = themes
+	}
+
+	// logger
+	if c.logger.val == nil {
+		c.logger.update(slog.New(discardHandler{}))
+	}
+
+	// extensions
+	if !c.exts.set {
+		c.exts.update(defaultExts)
+	}
+	if err := validateExts(c.logger.val, c.exts.val); err != nil {
+		return err
+	}
+
+	// include and exclude patterns
+	for _, pattern := range slices.Concat(c.include.val, c.exclude.val, c.standalone.val) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+		}
+	}
+
+	// body section
+	if !c.bodySection.set {
+		c.bodySection.update(defaultBodySection)
+	}
+	if c.bodySection.val == "" {
+		return errors.New("body section name not specified")
+	}
+
+	// head section
+	if !c.headSection.set {
+		c.headSection.update(defaultHeadSection)
+	}
+	if c.headSection.val == "" {
+		return errors.New("head section name not specified")
+	}
+	if c.headSection.val == c.bodySection.val {
+		return fmt.Errorf("head section '%s' conflicts with the body section", c.headSection.val)
+	}
+	if !c.autoHead.set {
+		c.autoHead.update(true)
+	}
+
+	// layout
+	if c.layoutReader.set {
+		if c.layout.set {
+			return errors.New("layout configured both as a file and as a reader")
+		}
+		if c.layoutReader.val == nil {
+			return errors.New("layout reader not specified")
+		}
+		f, err := readAll(c.layoutReader.val, c.maxFileSize.val)
+		if err != nil {
+			return fmt.Errorf("error reading layout: %w", err)
+		}
+		c.layout.update(readerLayoutName)
+		c.layoutRaw = f
+	} else if c.layout.set && c.layout.val != DefaultLayoutName {
+		if err := validateLayoutFile(c.exts.val, c.layout.val); err != nil {
+			return fmt.Errorf("invalid layout file: %w", err)
+		}
+		f, err := readFile(c.fs, c.layout.val, c.maxFileSize.val)
+		if err != nil {
+			return fmt.Errorf("error reading layout file '%s': %w", c.layout.val, err)
+		}
+		c.layoutRaw = f
+	} else {
+		c.layout.update(DefaultLayoutName)
+		c.layoutRaw = strings.Replace(defaultLayout, strconv.Quote(defaultHeadSection), strconv.Quote(c.headSection.val), 1)
+	}
+
+	// max file size
+	if c.maxFileSize.val < 0 {
+		return fmt.Errorf("invalid max file size: %d", c.maxFileSize.val)
+	}
+
+	// buffer pool
+	if c.bufferPool.val < 0 {
+		return fmt.Errorf("invalid buffer pool capacity: %d", c.bufferPool.val)
+	}
+
+	// render timeout
+	if c.renderTimeout.val < 0 {
+		return fmt.Errorf("invalid render timeout: %s", c.renderTimeout.val)
+	}
+
+	// parse workers
+	if !c.parseWorkers.set {
+		c.parseWorkers.update(1)
+	}
+	if c.parseWorkers.val < 1 {
+		return fmt.Errorf("invalid number of parse workers: %d", c.parseWorkers.val)
+	}
+
+	// component directory
+	if c.componentDir.set && (c.componentDir.val == "." || !fs.ValidPath(c.componentDir.val)) {
+		return fmt.Errorf("invalid component directory '%s'", c.componentDir.val)
+	}
+	if c.partialResolver.set && c.partialResolver.val == nil {
+		return errors.New("partial resolver not specified")
+	}
+
+	// status mapper
+	if c.statusMapper.set && c.statusMapper.val == nil {
+		return errors.New("status mapper not specified")
+	}
+
+	// watch
+	if c.watch.val && !c.watchInterval.set {
+		c.watchInterval.update(defaultWatchInterval)
+	}
+
+	// funcMap
+	if c.translator.set && c.translator.val == nil {
+		return errors.New("translator not specified")
+	}
+	if c.sanitizer.set && c.sanitizer.val == nil {
+		return errors.New("sanitizer not specified")
+	}
+	funcMap := placeholderFuncs()
+	if c.componentDir.set {
+		funcMap[componentFunc] = func(string, ...any) string { return "" }
+	}
+	for k, f := range builtinFuncs(c) {
+		funcMap[k] = f
+	}
+	c.renderFuncs = template.FuncMap{}
+	for k, f := range renderFuncs(c, nil) {
+		funcMap[k] = f
+		if _, ok := c.funcMap.val[k]; !ok {
+			c.renderFuncs[k] = f
+		}
+	}
+	c.userFuncs = c.funcMap.val
+	if c.funcMap.set {
+		for k, f := range c.funcMap.val {
+			funcMap[k] = f
+		}
+	}
+	// partial functions are parsed and executed as any other function,
+	// they are only restricted during assembly.
+	for k, f := range c.partialFuncMap.val {
+		if _, ok := c.funcMap.val[k]; ok {
+			return fmt.Errorf("partial function '%s' conflicts with an existing function", k)
+		}
+		funcMap[k] = f
+	}
+	// factory functions are created on each render and called by the copy of the view executing it,
+	// they are declared for parsing with an empty render context, see [moldEngine.acquire].
+	if c.funcMapFactory.set {
+		if c.funcMapFactory.val == nil {
+			return errors.New("function map factory not specified")
+		}
+		for k, f := range c.funcMapFactory.val(RenderContext{Context: context.Background()}) {
+			_, user := c.funcMap.val[k]
+			if _, partial := c.partialFuncMap.val[k]; user || partial {
+				return fmt.Errorf("factory function '%s' conflicts with an existing function", k)
+			}
+			funcMap[k] = f
+			c.renderFuncs[k] = f
+		}
+	}
+	// view functions are bound to each assembled view, they are only declared for parsing
+	// and restricted during assembly, see [otherViewFuncs].
+	viewFuncs := map[string]bool{}
+	for _, view := range sortedKeys(c.viewFuncMaps) {
+		for k := range c.viewFuncMaps[view] {
+			if _, ok := funcMap[k]; ok && !viewFuncs[k] {
+				return fmt.Errorf("view function '%s' conflicts with an existing function", k)
+			}
+			viewFuncs[k] = true
+			funcMap[k] = func(...any) (string, error) {
+				return "", fmt.Errorf("function %q not available in the view", k)
+			}
+		}
+	}
+	if c.markdown.set {
+		// declared for parsing, bound to each assembled view
+		for k, f := range markdownFuncs(nil, c.markdown.val) {
+			funcMap[k] = f
+		}
+	}
+	if c.strictArgs.val {
+		funcMap[expectsFunc] = expects
+	}
+	funcMap[partialArgsFunc] = func(args ...any) partialArgs { return partialArgs{Args: args} }
+	funcMap[partialKwargsFunc] = partialKwargs
+	funcMap[partialOrDataFunc] = newPartialOrData
+	if c.sourceComments.val {
+		funcMap[sourceCommentFunc] = func(label string) template.HTML {
+			return template.HTML("<!-- " + strings.ReplaceAll(label, "--", "") + " -->")
+		}
+	}
+	c.funcMap.update(funcMap)
+
+	// minifier
+	if c.minify.val && c.minifier.val == nil {
+		c.minifier.update(func(b []byte) ([]byte, error) { return minifyHTML(b), nil })
+	}
+
+	// source comments
+	if c.sourceComments.val {
+		switch {
+		case c.textMode.val:
+			return errors.New("source comments not supported in text mode")
+		case c.minify.val || c.stripComments.val:
+			return errors.New("source comments cannot be combined with minify or strip comments")
+		}
+	}
+
+	// base template
+	if base := c.baseTemplate.val; base != nil {
+		if c.textMode.val {
+			return errors.New("base template not supported in text mode")
+		}
+		if _, err := base.Clone(); err != nil {
+			return fmt.Errorf("invalid base template: %w", err)
+		}
+	}
+
+	// template options
+	if err := validateTemplateOptions(c.templateOptions.val); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// newTemplate allocates a new template with the configured functions and options.
+func (c *Config) newTemplate(name string) *template.Template {
+	if base := c.baseTemplate.val; base != nil {
+		return template.Must(base.Clone()).New(name).Funcs(c.funcMap.val).Option(c.templateOptions.val...) // validated by setup
+	}
+	return template.New(name).Funcs(c.funcMap.val).Option(c.templateOptions.val...)
+}
+
+// delims returns the action delimiters of templates, set with [template.Template.Delims] on the
+// base template, see [WithBaseTemplate]. They are not exported by html/template.
+func (c *Config) delims() (left, right string) {
+	left, right = "{{", "}}"
+	base := c.baseTemplate.val
+	if base == nil {
+		return left, right
+	}
+	text := reflect.ValueOf(base).Elem().FieldByName("text")
+	if !text.IsValid() || text.Kind() != reflect.Pointer || text.IsNil() {
+		return left, right
+	}
+	if l := text.Elem().FieldByName("leftDelim"); l.IsValid() && l.Kind() == reflect.String && l.String() != "" {
+		left = l.String()
+	}
+	if r := text.Elem().FieldByName("rightDelim"); r.IsValid() && r.Kind() == reflect.String && r.String() != "" {
+		right = r.String()
+	}
+	return left, right
+}
+
+// validateTemplateOptions reports an error for options not supported by [template.Template.Option],
+// which would otherwise panic.
+func validateTemplateOptions(opts []string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid template option: %v", r)
+		}
+	}()
+	template.New("").Option(opts...)
+	return nil
+}
+
+func parseLayout(c *Config, root templateSet) (*templateFile, error) {
+	return parseLayoutFile(c, root, c.layout.val, c.layoutRaw)
+}
+
+// partialResolver returns a function parsing the partials provided by the configured resolver,
+// or nil if not configured, see [WithPartialResolver].
+func partialResolver(c *Config) func(string) (*templateFile, error) {
+	if !c.partialResolver.set {
+		return nil
+	}
+	return func(name string) (*templateFile, error) {
+		body, ok := c.partialResolver.val.Resolve(name)
+		if !ok {
+			return nil, nil
+		}
+		t, err := parseFile(c, name, body)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving partial '%s': %w", name, err)
+		}
+		if c.strictFuncs.val {
+			if err := errors.Join(checkFuncs(t, c.funcMap.val)...); err != nil {
+				return nil, err
+			}
+		}
+		t.resolved = true
+		return t, nil
+	}
+}
+
+func parseLayoutFile(c *Config, root templateSet, name, source string) (*templateFile, error) {
+	left, right := c.delims()
+	layoutRaw, edits := rewriteKeywordArgs(source, left, right)
+	t, err := c.newTemplate("layout").Parse(layoutRaw)
+	if err != nil {
+		return nil, newParseError(name, source, left, c.errorSnippets.val, err)
+	}
+	// execution errors are reported with the path of the layout file, rather than the template name
+	for _, tpl := range t.Templates() {
+		if tpl.Tree != nil && tpl.Tree.ParseName == t.Name() {
+			tpl.Tree.ParseName = name
+		}
+	}
+
+	layout := &templateFile{
+		Template:    t,
+		typ:         layoutType,
+		body:        layoutRaw,
+		source:      source,
+		edits:       edits,
+		leftDelim:   left,
+		path:        name,
+		bodySection: c.bodySection.val,
+
+		componentDir:   c.componentDir.val,
+		sourceComments: c.sourceComments.val,
+		errorSnippets:  c.errorSnippets.val,
+		resolve:        partialResolver(c),
+	}
+
+	if c.strictFuncs.val {
+		if err := errors.Join(checkFuncs(layout, c.funcMap.val)...); err != nil {
+			return nil, err
+		}
+	}
+	if callsFuncs(layout, c.partialFuncMap.val) {
+		return nil, errors.New("layout calls functions only available in partials")
+	}
+
+	// process template tree for layout
+	refs, err := processTree(layout, root)
+	if err != nil {
+		return nil, fmt.Errorf("error processing layout: %w", err)
+	}
+	for _, ref := range refs {
+		// sections not defined by a view render nothing
+		if ref.typ == renderFunc && root[ref.name] == nil {
+			tpl, _ := template.New(ref.name).Parse("") // safe to ignore the err
+			layout.AddParseTree(ref.name, tpl.Tree)
+		}
+	}
+	err = resolvePartials(root, refs, []string{layout.path}, map[string]bool{}, func(name string, t *templateFile) {
+		addPartial(layout.Template, name, t)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return layout, nil
+}
+
+// stackLayouts composes the layouts, ordered from the outermost to the innermost, into a single layout.
+// The body of each layout renders the next inner layout, the innermost renders the view.
+// Sections are shared by all layouts.
+func stackLayouts(c *Config, set templateSet, names []string) (*templateFile, error) {
+	stack := &templateFile{Template: c.newTemplate("layout"), typ: layoutType, path: names[0], bodySection: c.bodySection.val}
+
+	for i, name := range names {
+		if err := validateLayoutFile(c.exts.val, name); err != nil {
+			return nil, fmt.Errorf("invalid layout file '%s': %w", name, err)
+		}
+		f, err := readFile(c.fs, name, c.maxFileSize.val)
+		if errors.Is(err, fs.ErrNotExist) {
+			// reported as a missing view, e.g. for a layout chosen at render time
+			return nil, fmt.Errorf("layout file '%s': %w", name, ErrNotFound)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading layout file '%s': %w", name, err)
+		}
+		layout, err := parseLayoutFile(c, set, name, f)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing layout '%s': %w", name, err)
+		}
+		stack.refs = append(stack.refs, layout.refs...)
+
+		for _, t := range layout.Templates() {
+			if t.Tree == nil {
+				continue
+			}
+			tName, tree := t.Name(), t.Tree.Copy()
+			if t == layout.Template {
+				if i > 0 {
+					tName = name
+				}
+				if i < len(names)-1 {
+					renameTemplateRefs(tree.Root, c.bodySection.val, names[i+1])
+				}
+			}
+			_, _ = stack.AddParseTree(tName, tree) // safe, not executed
+		}
+	}
+
+	return stack, nil
+}
+
+// parseView assembles the view with the layout.
+//
+// The trees of the view and its partials are copied, as html/template escapes
+// trees in place on first execution and a tree must not be shared between views
+// that may be executed concurrently.
+func parseView(set templateSet, layout *templateFile, name string) (*template.Template, error) {
+	view := template.Must(layout.Clone()) // safe
+
+	body := set[name]
+	body.typ = viewType
 
-	// traverse to fetch all templates
-	set, err := walk(c.fs, c.exts.val, c.funcMap.val)
+	// process template tree for body
+	refs, err := processTree(body, set)
 	if err != nil {
-		return nil, fmt.Errorf("error creating new engine: %w", err)
+		return nil, fmt.Errorf("error parsing view '%s': %w", name, err)
 	}
-
-	// process layout
-	layout, err := parseLayout(set, c.layoutRaw, c.funcMap.val)
+	// views without partials nor sections only replace the body section of the layout
+	if len(refs) == 0 && len(definedTemplates(body)) == 0 {
+		_, _ = view.AddParseTree(layout.bodySection, body.Tree.Copy()) // safe, not executed
+		return view, nil
+	}
+	err = resolvePartials(set, refs, []string{name}, map[string]bool{}, func(name string, t *templateFile) {
+		addPartial(view, name, t)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error parsing layout: %w", err)
+		return nil, err
 	}
 
-	// process views
-	for name := range set {
-		view, err := parseView(set, layout, name)
-		if err != nil {
-			return nil, err
+	// add defined templates to the layout
+	for _, t := range body.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		tName := t.Name()
+		if tName == name {
+			tName = layout.bodySection
 		}
-		m[name] = view
+		view.AddParseTree(tName, t.Tree.Copy())
 	}
 
-	return m, nil
+	return view, nil
 }
 
-// Render implements Layout.
-func (m moldEngine) Render(w io.Writer, view string, data any) error {
-	layout, ok := m[view]
-	if !ok {
-		return ErrNotFound
-	}
-
-	if err := layout.Execute(w, data); err != nil {
-		return fmt.Errorf("error rendering '%s': %w", view, err)
-	}
-
-	return nil
-}
+// templateCycle returns the chain of templates invoking each other unconditionally, starting from
+// the root template, e.g. a section rendering the body which renders the section, or nil if there is none.
+// Invocations within conditional actions are ignored, as recursive templates bounded by the data are valid.
+func templateCycle(view *template.Template, root string) []string {
+	var stack []string
+	done := map[string]bool{}
 
-func walk(fsys fs.FS, exts []string, funcMap template.FuncMap) (set templateSet, err error) {
-	set = templateSet{}
-	err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		if i := slices.Index(stack, name); i >= 0 {
+			return append(slices.Clone(stack[i:]), name)
 		}
-
-		// skip hidden files and directories.
-		if strings.HasPrefix(d.Name(), ".") && d.Name() != "." {
-			if d.IsDir() {
-				return fs.SkipDir
-			}
+		t := view.Lookup(name)
+		if done[name] || t == nil || t.Tree == nil {
 			return nil
 		}
 
-		if d.IsDir() {
-			return nil
+		stack = append(stack, name)
+		for _, ref := range unconditionalRefs(t.Tree.Root) {
+			if cycle := visit(ref); cycle != nil {
+				return cycle
+			}
 		}
+		stack = stack[:len(stack)-1]
+		done[name] = true
+		return nil
+	}
+	return visit(root)
+}
 
-		ext := filepath.Ext(d.Name())
-		if !hasExt(exts, ext) {
-			return nil
+// unusedSections returns the sections defined by the view that are not rendered
+// by the layout, the view itself or any of the partials.
+// Templates defined by the base template, if any, are not sections.
+func unusedSections(view *template.Template, body *templateFile, base *template.Template) (unused []string) {
+	used := map[string]bool{}
+	for _, t := range view.Templates() {
+		if t.Tree == nil {
+			continue
 		}
-
-		// skip layout files
-		if err := validateLayoutFile(exts, path); err == nil {
-			return nil
+		for _, name := range templateRefs(t.Tree.Root) {
+			used[name] = true
 		}
+	}
 
-		f, err := readFile(fsys, path)
-		if err != nil {
-			return err
+	for _, t := range body.Templates() {
+		if name := t.Name(); name != body.Name() && !used[name] && (base == nil || base.Lookup(name) == nil) {
+			unused = append(unused, name)
 		}
+	}
+	sort.Strings(unused)
 
-		if t, err := template.New(path).Funcs(funcMap).Parse(f); err != nil {
-			return fmt.Errorf("error parsing template '%s': %w", path, err)
-		} else {
-			set[path] = &templateFile{Template: t, body: f}
-		}
+	return unused
+}
 
-		return nil
-	})
+// emptySections returns the sections rendered by the layout that remain empty in the view,
+// i.e. neither defined by the view, even if empty, nor with a default in the layout or the base template.
+func emptySections(view *template.Template, body, layout *templateFile) (empty []string) {
+	seen := map[string]bool{}
+	for _, ref := range layout.refs {
+		if ref.typ != renderFunc || ref.name == layout.bodySection || seen[ref.name] {
+			continue
+		}
+		seen[ref.name] = true
+		if body.Lookup(ref.name) != nil {
+			continue
+		}
+		if t := view.Lookup(ref.name); t == nil || t.Tree == nil || parse.IsEmptyTree(t.Tree.Root) {
+			empty = append(empty, ref.name)
+		}
+	}
+	sort.Strings(empty)
 
-	return
+	return empty
 }
 
-func setup(c *Config, options ...Option) error {
-	// apply options
-	for _, opt := range options {
-		opt(c)
+// key returns the canonical form of the overrides, identifying the variants of views.
+func (o Overrides) key() string {
+	var b strings.Builder
+	for _, from := range sortedKeys(o) {
+		fmt.Fprintf(&b, "%q:%q,", from, o[from])
 	}
+	return b.String()
+}
 
-	// root
-	if c.root.set {
-		sub, err := fs.Sub(c.fs, c.root.val)
-		if err != nil {
-			return fmt.Errorf("error setting subdirectory '%s': %w", c.root.val, err)
+// overridePartials replaces the partials of the assembled view with the partials of the overrides,
+// adding the partials they reference.
+func overridePartials(set templateSet, view *template.Template, overrides Overrides) error {
+	seen := map[string]bool{}
+	for _, from := range sortedKeys(overrides) {
+		to := overrides[from]
+		f, t := set[from], set[to]
+		if f == nil || t == nil || f.markdown || t.markdown {
+			return fmt.Errorf("error overriding partial '%s' with '%s': %w", from, to, ErrNotFound)
 		}
-		c.fs = sub
-	}
-
-	// extensions
-	if !c.exts.set {
-		c.exts.update(defaultExts)
-	}
 
-	// layout
-	if c.layout.set {
-		if err := validateLayoutFile(c.exts.val, c.layout.val); err != nil {
-			return fmt.Errorf("invalid layout file: %w", err)
+		refs, err := processTree(t, set)
+		if err != nil {
+			return fmt.Errorf("error parsing partial: '%s': %w", to, err)
 		}
-		f, err := readFile(c.fs, c.layout.val)
+		// the overridden partials are added last, once the partials they reference are added
+		err = resolvePartials(set, refs, []string{from, to}, seen, func(name string, t *templateFile) {
+			if _, ok := overrides[name]; !ok {
+				addPartial(view, name, t)
+			}
+		})
 		if err != nil {
-			return fmt.Errorf("error reading layout file '%s': %w", c.layout.val, err)
+			return err
 		}
-		c.layoutRaw = f
-	} else {
-		c.layout.update("default_layout")
-		c.layoutRaw = defaultLayout
 	}
-
-	// funcMap
-	funcMap := placeholderFuncs()
-	if c.funcMap.set {
-		for k, f := range c.funcMap.val {
-			funcMap[k] = f
-		}
+	for _, from := range sortedKeys(overrides) {
+		addPartial(view, from, set[overrides[from]])
 	}
-	c.funcMap.update(funcMap)
-
 	return nil
 }
 
-func parseLayout(root templateSet, layoutRaw string, funcMap template.FuncMap) (*templateFile, error) {
-	t, err := template.New("layout").Funcs(funcMap).Parse(layoutRaw)
-	if err != nil {
-		return nil, err
+// addPartial adds a copy of the partial to the template under name, with the templates it defines.
+// The templates defined by a partial are scoped to it, so that they neither collide with the sections of views
+// nor with the templates of other partials: they are renamed, as are the template calls of the partial to them.
+func addPartial(to *template.Template, name string, t *templateFile) {
+	defines := definedTemplates(t)
+	trees := map[string]*parse.Tree{name: t.Tree.Copy()}
+	for _, d := range defines {
+		trees[scopedName(name, d)] = t.Lookup(d).Tree.Copy()
 	}
-
-	layout := &templateFile{
-		Template: t,
-		typ:      layoutType,
-		body:     layoutRaw,
+	for _, tree := range trees {
+		for _, d := range defines {
+			renameTemplateRefs(tree.Root, d, scopedName(name, d))
+		}
 	}
-
-	// process template tree for layout
-	refs, err := processTree(layout)
-	if err != nil {
-		return nil, fmt.Errorf("error processing layout: %w", err)
+	for _, n := range sortedKeys(trees) {
+		_, _ = to.AddParseTree(n, trees[n]) // safe, not executed
 	}
+}
+
+// scopedName returns the name of the template defined by the partial, see [addPartial].
+func scopedName(partial, name string) string {
+	return partial + "#" + name
+}
+
+// resolvePartials processes the templates referenced by refs and the partials they reference in turn,
+// calling add once for each of them.
+//
+// stack is the chain of templates referencing refs, used to detect cyclic references.
+func resolvePartials(set templateSet, refs []nestedFile, stack []string, seen map[string]bool, add func(string, *templateFile)) error {
 	for _, ref := range refs {
-		t := root[ref.name]
+		if i := slices.Index(stack, ref.name); i >= 0 {
+			cycle := append(slices.Clone(stack[i:]), ref.name)
+			return fmt.Errorf("error parsing partial: '%s': cyclic reference: %s", ref.name, strings.Join(cycle, " -> "))
+		}
+		if seen[ref.name] {
+			continue
+		}
+
+		t := set[ref.name]
 		if t == nil {
-			if ref.typ == partialFunc {
-				return nil, fmt.Errorf("error parsing template '%s': %w", ref.name, ErrNotFound)
+			if ref.typ == renderFunc {
+				continue
 			}
-			tpl, _ := template.New(ref.name).Parse("") // safe to ignore the err
-			t = &templateFile{Template: tpl}
+			return fmt.Errorf("error parsing template '%s': %w", ref.name, ErrNotFound)
 		}
 
 		t.typ = partialType
-		if err := parsePartial(t); err != nil {
-			return nil, fmt.Errorf("error parsing partial: '%s': %w", ref.name, err)
+		nested, err := processTree(t, set)
+		if err != nil {
+			return fmt.Errorf("error parsing partial: '%s': %w", ref.name, err)
+		}
+		if err := resolvePartials(set, nested, append(stack[:len(stack):len(stack)], ref.name), seen, add); err != nil {
+			return err
 		}
 
-		layout.AddParseTree(ref.name, t.Tree)
+		seen[ref.name] = true
+		if !t.markdown { // executed separately
+			add(ref.name, t)
+		}
 	}
-
-	return layout, nil
+	return nil
 }
 
-func parseView(set templateSet, layout *templateFile, name string) (*template.Template, error) {
-	view := template.Must(layout.Clone()) // safe
-
-	body := set[name]
-	body.typ = viewType
-
-	// process template tree for body
-	refs, err := processTree(body)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing view '%s': %w", name, err)
+// sortedKeys returns the keys of the map in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
-	for _, ref := range refs {
-		t := set[ref.name]
-		if t == nil {
-			return nil, fmt.Errorf("error parsing template '%s': %w", ref.name, ErrNotFound)
-		}
+	sort.Strings(keys)
+	return keys
+}
 
-		t.typ = partialType
-		if err := parsePartial(t); err != nil {
-			return nil, fmt.Errorf("error parsing partial: '%s': %w", ref.name, err)
+// readFile reads the file, refusing files larger than limit bytes unless limit is 0.
+func readFile(fsys fs.FS, name string, limit int64) (string, error) {
+	if limit <= 0 {
+		f, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return "", fmt.Errorf("error reading file: %w", err)
 		}
-
-		view.AddParseTree(ref.name, t.Tree)
+		return string(f), nil
 	}
 
-	// add defined templates to the layout
-	for _, t := range body.Templates() {
-		tName := t.Name()
-		if tName == name {
-			tName = "body"
-		}
-		view.AddParseTree(tName, t.Tree)
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("error reading file: %w", err)
 	}
+	defer f.Close()
 
-	return view, nil
+	if info, err := f.Stat(); err == nil && info.Size() > limit {
+		return "", fmt.Errorf("error reading file: size of %d bytes exceeds the limit of %d bytes", info.Size(), limit)
+	}
+	// the size reported may be inaccurate, the read is limited regardless
+	b, err := readAll(f, limit)
+	if err != nil {
+		return "", fmt.Errorf("error reading file: %w", err)
+	}
+	return b, nil
 }
 
-func parsePartial(partial *templateFile) error {
-	_, err := processTree(partial)
-	return err
-}
+// readAll reads r until EOF, failing if more than limit bytes are read, unless the limit is not positive.
+func readAll(r io.Reader, limit int64) (string, error) {
+	if limit <= 0 {
+		b, err := io.ReadAll(r)
+		return string(b), err
+	}
 
-func readFile(fsys fs.FS, name string) (string, error) {
-	f, err := fs.ReadFile(fsys, name)
+	b, err := io.ReadAll(io.LimitReader(r, limit+1))
 	if err != nil {
-		return "", fmt.Errorf("error reading file: %w", err)
+		return "", err
 	}
-
-	return string(f), nil
+	if int64(len(b)) > limit {
+		return "", fmt.Errorf("size exceeds the limit of %d bytes", limit)
+	}
+	return string(b), nil
 }
 
 func hasExt(exts []string, ext string) bool {
@@ -269,10 +2114,80 @@ func hasExt(exts []string, ext string) bool {
 	return false
 }
 
+// matchExt returns the longest of the extensions the file name ends with, case insensitively.
+// Compound extensions e.g. ".html.tmpl" are supported.
+// It returns an empty string if there is none.
+func matchExt(exts []string, name string) (ext string) {
+	lower := strings.ToLower(path.Base(name))
+	for _, e := range exts {
+		e = "." + strings.ToLower(strings.TrimPrefix(e, "."))
+		if e != "." && len(e) > len(ext) && len(lower) >= len(e) && strings.HasSuffix(lower, e) {
+			ext = e
+		}
+	}
+	if ext == "" {
+		return ""
+	}
+	return name[len(name)-len(ext):]
+}
+
+// validateExts reports an error for filename extensions never matching a file name, and logs a warning
+// for extensions matching the same files as others, as only the longest matching extension applies.
+func validateExts(logger *slog.Logger, exts []string) error {
+	seen := map[string]string{}
+	for _, ext := range exts {
+		e := "." + strings.ToLower(strings.TrimPrefix(ext, "."))
+		if e == "." || strings.Contains(e, "/") {
+			return fmt.Errorf("invalid filename extension '%s'", ext)
+		}
+		if prev, ok := seen[e]; ok {
+			logger.Warn("duplicate filename extension", "ext", ext, "duplicate", prev)
+			continue
+		}
+		seen[e] = ext
+	}
+
+	// e.g. ".tmpl" and ".html.tmpl", the latter applies to "page.html.tmpl"
+	for _, e := range sortedKeys(seen) {
+		for _, other := range sortedKeys(seen) {
+			if other != e && strings.HasSuffix(other, e) {
+				logger.Warn("overlapping filename extensions, the longest matching one applies", "ext", seen[e], "overlapping", seen[other])
+			}
+		}
+	}
+	return nil
+}
+
+// filtered reports whether the file is excluded from parsing by the include and exclude patterns.
+func (c *Config) filtered(name string) bool {
+	if c.include.set && !matchAny(c.include.val, name) {
+		return true
+	}
+	for dir := name; dir != "."; dir = path.Dir(dir) {
+		if matchAny(c.exclude.val, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny reports whether the path matches any of the patterns.
+// The patterns must have been validated.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validateLayoutFile reports an error if the file is not a layout file,
+// i.e. suffixed with "layout" before the longest matching filename extension.
 func validateLayoutFile(exts []string, name string) error {
-	ext := filepath.Ext(name)
-	if !hasExt(exts, ext) {
-		return fmt.Errorf("unsupported filename extension '%s'", ext)
+	ext := matchExt(exts, name)
+	if ext == "" {
+		return fmt.Errorf("unsupported filename extension '%s'", filepath.Ext(name))
 	}
 
 	nameOnly := strings.TrimSuffix(name, ext)
@@ -285,11 +2200,214 @@ func validateLayoutFile(exts []string, name string) error {
 
 func placeholderFuncs() template.FuncMap {
 	return map[string]any{
-		renderFunc.String():  func(...string) string { return "" },
+		renderFunc.String():  func(...any) string { return "" },
 		partialFunc.String(): func(string, ...any) string { return "" },
+		layoutsFunc:          func(...string) string { return "" },
+		cachedPartialFunc:    func(string, ...any) string { return "" },
+		optionalPartialFunc:  func(string, ...any) string { return "" },
+		eachPartialFunc:      func(string, ...any) string { return "" },
+		partialOrFunc:        func(string, ...any) string { return "" },
+	}
+}
+
+// errPartialOnly is returned for templates that call functions only available in partials,
+// which cannot be rendered as views.
+var errPartialOnly = fmt.Errorf("calls functions only available in partials: %w", ErrNotFound)
+
+// builtinFuncs returns the template functions provided by the engine.
+// They can be overridden with custom functions of the same name.
+func builtinFuncs(c *Config) template.FuncMap {
+	globals, fsys, limit := c.globals.val, c.fs, c.maxFileSize.val
+	funcs := map[string]any{
+		"globals": func() map[string]any { return globals },
+		"include": func(name string) (template.HTML, error) {
+			return include(fsys, name, limit)
+		},
+		"lazy":    lazy,
+		"safe":    func(s string) template.HTML { return template.HTML(s) },
+		"safeURL": func(s string) template.URL { return template.URL(s) },
+		"safeCSS": func(s string) template.CSS { return template.CSS(s) },
+		"safeJS":  func(s string) template.JS { return template.JS(s) },
 	}
+	if c.sanitizer.set {
+		sanitize := c.sanitizer.val
+		funcs["sanitize"] = func(s string) template.HTML { return template.HTML(sanitize(s)) }
+	}
+	return funcs
+}
+
+// renderFuncs returns the template functions depending on per-render values, see [Engine.RenderWith].
+// Outside of RenderWith, they are bound to empty values by [setup].
+func renderFuncs(c *Config, values map[string]any) template.FuncMap {
+	funcs := template.FuncMap{
+		"nonce": func() string {
+			nonce, _ := values["nonce"].(string)
+			return nonce
+		},
+	}
+	request := func() *http.Request {
+		r, _ := values["request"].(*http.Request)
+		return r
+	}
+	funcs["currentPath"] = func() string {
+		if r := request(); r != nil {
+			return r.URL.Path
+		}
+		return ""
+	}
+	funcs["query"] = func(key string) string {
+		if r := request(); r != nil {
+			return r.URL.Query().Get(key)
+		}
+		return ""
+	}
+	funcs["isActive"] = func(link string) bool {
+		return isActive(request(), link)
+	}
+	if c.translator.set {
+		translate := c.translator.val
+		funcs["t"] = func(key string, args ...any) string {
+			locale, _ := values["locale"].(string)
+			return translate(locale, key, args...)
+		}
+	}
+	return funcs
+}
+
+// include reads the file from the filesystem as trusted HTML.
+// Hidden files and files in hidden directories, e.g. ".env", are not included,
+// as the name may be provided by the data.
+func include(fsys fs.FS, name string, limit int64) (template.HTML, error) {
+	if !fs.ValidPath(name) {
+		return "", fmt.Errorf("error including file '%s': invalid path", name)
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") && elem != "." {
+			return "", fmt.Errorf("error including file '%s': %w", name, fs.ErrNotExist)
+		}
+	}
+	f, err := readFile(fsys, name, limit)
+	if err != nil {
+		return "", fmt.Errorf("error including file '%s': %w", name, err)
+	}
+	return template.HTML(f), nil
+}
+
+// expects reports an error if the data of the partial is missing any of the fields.
+func expects(name string, data any, fields ...string) (string, error) {
+	for _, field := range fields {
+		if !hasField(data, field) {
+			return "", fmt.Errorf("partial '%s' expects field .%s, not found in %T", name, field, data)
+		}
+	}
+	return "", nil
+}
+
+// hasField reports whether the field can be evaluated on data by a template,
+// i.e. it is a key of a map, or a field or method of a struct.
+func hasField(data any, field string) bool {
+	v := reflect.ValueOf(data)
+	if !v.IsValid() {
+		return false
+	}
+	for {
+		if _, ok := v.Type().MethodByName(field); ok {
+			return true
+		}
+		if v.Kind() != reflect.Pointer && v.Kind() != reflect.Interface {
+			break
+		}
+		if v.IsNil() {
+			return false
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return false
+		}
+		return v.MapIndex(reflect.ValueOf(field).Convert(v.Type().Key())).IsValid()
+	case reflect.Struct:
+		f, ok := v.Type().FieldByName(field)
+		return ok && f.IsExported()
+	}
+	return false
+}
+
+// mergeGlobals merges the globals with data if data is a map.
+// Values in data take precedence over globals with the same key.
+func mergeGlobals(globals map[string]any, data any) any {
+	if len(globals) == 0 {
+		return data
+	}
+
+	var m map[string]any
+	switch data := data.(type) {
+	case nil:
+	case map[string]any:
+		m = data
+	default:
+		return data
+	}
+
+	merged := make(map[string]any, len(globals)+len(m))
+	for k, v := range globals {
+		merged[k] = v
+	}
+	for k, v := range m {
+		merged[k] = v
+	}
+	return merged
+}
+
+// executor executes an assembled view.
+// It is implemented by both html/template and text/template templates.
+type executor interface {
+	Execute(w io.Writer, data any) error
+	ExecuteTemplate(w io.Writer, name string, data any) error
+}
+
+// compiledView is an assembled view ready for execution.
+type compiledView struct {
+	tmpl *template.Template
+	exec executor
+
+	// the functions of a template cannot change once executed, views depending on the render
+	// are executed by copies bound to the render, see [moldEngine.acquire]
+	scoped bool         // calls functions depending on the render, see [Config.renderFuncs]
+	copies sync.Pool    // of *compiledView, released copies of a scoped view
+	scope  *renderScope // of copies only
+
+	emptySections []string // with [WithStrictSections], see [emptySections]
+	overrides     string   // see [Overrides.key]
+}
+
+// compile prepares the assembled view for execution.
+// In text mode, the parse trees of the view are executed with text/template instead.
+func compile(c *Config, view *template.Template) *compiledView {
+	if !c.textMode.val {
+		return &compiledView{tmpl: view, exec: view}
+	}
+
+	t := texttemplate.New(view.Name()).Funcs(c.funcMap.val).Option(c.templateOptions.val...)
+	for _, v := range view.Templates() {
+		if v.Tree != nil {
+			_, _ = t.AddParseTree(v.Name(), v.Tree) // safe, trees are valid
+		}
+	}
+	return &compiledView{tmpl: view, exec: t}
 }
 
+// discardHandler is a [slog.Handler] that discards all records.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
+
 type templateType string
 
 // template types
@@ -301,8 +2419,31 @@ const (
 
 type templateFile struct {
 	*template.Template
-	typ  templateType
-	body string
+	typ    templateType
+	body   string // rewritten for parsing, see [rewriteKeywordArgs]
+	source string // as read, see [Engine.Source]
+	edits  []edit // rewrites of the source, errors are reported at positions in the source
+	path   string // path in the filesystem, relative partial paths are resolved against it
+
+	leftDelim string // left action delimiter, see [Config.delims]
+
+	contentType string // declared with a leading comment
+	bodySection string // layouts only, the section holding the content of views
+	markdown    bool   // Markdown partial, see [WithMarkdown]
+
+	markdownTmpl *template.Template // Markdown partial executed on its own, see [markdownFuncs]
+
+	componentDir   string // see [WithComponentDir], empty if not configured
+	sourceComments bool   // see [WithSourceComments]
+	errorSnippets  bool   // see [WithErrorSnippets]
+
+	// resolves missing partials, nil if not configured, see [WithPartialResolver]
+	resolve  func(name string) (*templateFile, error)
+	resolved bool // provided by the resolver
+
+	// set once the tree is processed
+	refs      []nestedFile
+	processed bool
 }
 
 type optionVal[T any] struct {
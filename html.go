@@ -0,0 +1,203 @@
+package mold
+
+import (
+	"bytes"
+	"slices"
+)
+
+type htmlTokenType int
+
+// html token types
+const (
+	htmlText htmlTokenType = iota
+	htmlTag
+	htmlComment
+	htmlRawText
+)
+
+type htmlToken struct {
+	typ  htmlTokenType
+	data []byte
+}
+
+// rawTextElements are elements whose content is not parsed as HTML markup.
+var rawTextElements = []string{"script", "style", "textarea", "title"}
+
+// scanHTML splits b into a sequence of HTML tokens and calls fn for each of them.
+// It is a lightweight scanner that only distinguishes text, tags, comments and
+// the content of raw text elements. Concatenating the data of all tokens yields b.
+func scanHTML(b []byte, fn func(htmlToken)) {
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '<')
+		if i < 0 {
+			fn(htmlToken{typ: htmlText, data: b})
+			return
+		}
+		if i > 0 {
+			fn(htmlToken{typ: htmlText, data: b[:i]})
+			b = b[i:]
+		}
+
+		// comment
+		if bytes.HasPrefix(b, []byte("<!--")) {
+			end := bytes.Index(b[4:], []byte("-->"))
+			if end < 0 {
+				fn(htmlToken{typ: htmlText, data: b})
+				return
+			}
+			end += 4 + 3
+			fn(htmlToken{typ: htmlComment, data: b[:end]})
+			b = b[end:]
+			continue
+		}
+
+		// tag
+		end := bytes.IndexByte(b, '>')
+		if end < 0 || !isTagStart(b) {
+			fn(htmlToken{typ: htmlText, data: b[:1]})
+			b = b[1:]
+			continue
+		}
+		end++
+		tag := b[:end]
+		fn(htmlToken{typ: htmlTag, data: tag})
+		b = b[end:]
+
+		// content of raw text elements is emitted as is, up to the closing tag.
+		if name := tagName(tag); isRawTextElement(name) && tag[1] != '/' {
+			closing := indexFold(b, []byte("</"+name))
+			if closing < 0 {
+				closing = len(b)
+			}
+			if closing > 0 {
+				fn(htmlToken{typ: htmlRawText, data: b[:closing]})
+			}
+			b = b[closing:]
+		}
+	}
+}
+
+// isTagStart reports whether b starts with a start tag, an end tag or a declaration.
+func isTagStart(b []byte) bool {
+	if len(b) < 2 {
+		return false
+	}
+	c := b[1]
+	if c == '/' || c == '!' || c == '?' {
+		if len(b) < 3 {
+			return false
+		}
+		c = b[2]
+		if b[1] != '/' {
+			return true
+		}
+	}
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// tagName returns the lowercased name of the tag.
+func tagName(tag []byte) string {
+	tag = bytes.TrimPrefix(tag[1:], []byte("/"))
+	end := bytes.IndexAny(tag, " \t\n\r\f/>")
+	if end < 0 {
+		end = len(tag)
+	}
+	return string(bytes.ToLower(tag[:end]))
+}
+
+func isRawTextElement(name string) bool {
+	for _, e := range rawTextElements {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// indexFold is like bytes.Index but case insensitive for ASCII.
+func indexFold(s, sep []byte) int {
+	for i := 0; i+len(sep) <= len(s); i++ {
+		if bytes.EqualFold(s[i:i+len(sep)], sep) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isConditionalComment reports whether the comment is an Internet Explorer conditional comment.
+func isConditionalComment(comment []byte) bool {
+	body := bytes.TrimSuffix(bytes.TrimPrefix(comment, []byte("<!--")), []byte("-->"))
+	return bytes.HasPrefix(body, []byte("[if")) || bytes.HasSuffix(body, []byte("<![endif]"))
+}
+
+// stripComments removes all HTML comments from b, except conditional comments.
+func stripComments(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	scanHTML(b, func(t htmlToken) {
+		if t.typ == htmlComment && !isConditionalComment(t.data) {
+			return
+		}
+		out = append(out, t.data...)
+	})
+	return out
+}
+
+// blockElements are elements around which whitespace is not significant for rendering.
+var blockElements = []string{
+	"!doctype", "address", "article", "aside", "base", "blockquote", "body", "br", "dd", "details", "dialog",
+	"div", "dl", "dt", "fieldset", "figcaption", "figure", "footer", "form", "h1", "h2", "h3", "h4", "h5", "h6",
+	"head", "header", "hgroup", "hr", "html", "li", "link", "main", "meta", "nav", "ol", "option", "p", "pre",
+	"script", "section", "style", "summary", "table", "tbody", "td", "tfoot", "th", "thead", "title", "tr", "ul",
+}
+
+// minifyHTML collapses whitespace in b, removing it entirely next to block elements
+// where it is not significant for rendering.
+// The content of "<pre>", "<textarea>", "<script>", "<style>" and "<title>" elements, as well as comments,
+// are left intact.
+func minifyHTML(b []byte) []byte {
+	var tokens []htmlToken
+	scanHTML(b, func(t htmlToken) { tokens = append(tokens, t) })
+
+	isBlockTag := func(i int) bool {
+		return i >= 0 && i < len(tokens) && tokens[i].typ == htmlTag && slices.Contains(blockElements, tagName(tokens[i].data))
+	}
+
+	out := make([]byte, 0, len(b))
+	pre := 0 // depth of pre elements
+	for i, t := range tokens {
+		switch {
+		case t.typ == htmlTag && tagName(t.data) == "pre":
+			if t.data[1] == '/' {
+				pre = max(pre-1, 0)
+			} else {
+				pre++
+			}
+		case t.typ != htmlText || pre > 0:
+		case len(bytes.TrimSpace(t.data)) == 0 && (isBlockTag(i-1) || isBlockTag(i+1) || i == 0 || i == len(tokens)-1):
+			continue
+		default:
+			out = append(out, collapseSpace(t.data)...)
+			continue
+		}
+		out = append(out, t.data...)
+	}
+	return out
+}
+
+// collapseSpace replaces each run of whitespace in b with a single space.
+func collapseSpace(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	space := false
+	for _, c := range b {
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' {
+			if !space {
+				out = append(out, ' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		out = append(out, c)
+	}
+	return out
+}
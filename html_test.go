@@ -0,0 +1,112 @@
+package mold
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestStripComments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input:    "",
+			expected: "",
+		},
+		{
+			input:    "<p>Hello</p>",
+			expected: "<p>Hello</p>",
+		},
+		{
+			input:    "<p><!-- comment -->Hello</p>",
+			expected: "<p>Hello</p>",
+		},
+		{
+			input:    "<!--a--><p>Hello</p><!--\nb\n-->",
+			expected: "<p>Hello</p>",
+		},
+		{
+			input:    "<!--[if IE]><p>IE</p><![endif]-->",
+			expected: "<!--[if IE]><p>IE</p><![endif]-->",
+		},
+		{
+			input:    "<!--[if !IE]><!--><p>Not IE</p><!--<![endif]-->",
+			expected: "<!--[if !IE]><!--><p>Not IE</p><!--<![endif]-->",
+		},
+		{
+			input:    "<script>var s = '<!-- not a comment -->';</script><!-- comment -->",
+			expected: "<script>var s = '<!-- not a comment -->';</script>",
+		},
+		{
+			input:    "<STYLE type=\"text/css\"><!-- p {} --></STYLE>",
+			expected: "<STYLE type=\"text/css\"><!-- p {} --></STYLE>",
+		},
+		{
+			input:    "<textarea><!-- text --></textarea>",
+			expected: "<textarea><!-- text --></textarea>",
+		},
+		{
+			input:    "<p>unterminated <!-- comment",
+			expected: "<p>unterminated <!-- comment",
+		},
+		{
+			input:    "1 < 2 <!-- comment --> 3 > 2",
+			expected: "1 < 2  3 > 2",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result := string(stripComments([]byte(tt.input)))
+			if result != tt.expected {
+				t.Errorf("stripComments(%q) = %q, expected %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMinifyHTML(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input:    "",
+			expected: "",
+		},
+		{
+			input:    "<!DOCTYPE html>\n<html>\n  <head>\n    <title> My  Page </title>\n  </head>\n</html>\n",
+			expected: "<!DOCTYPE html><html><head><title> My  Page </title></head></html>",
+		},
+		{
+			input:    "<ul>\n  <li>One</li>\n  <li>Two\n    items</li>\n</ul>",
+			expected: "<ul><li>One</li><li>Two items</li></ul>",
+		},
+		{
+			input:    "<p><b>bold</b>\n  <i>italic</i></p>",
+			expected: "<p><b>bold</b> <i>italic</i></p>",
+		},
+		{
+			input:    "<div>\n<pre>  keep\n   <b>this</b>\n</pre>\n</div>",
+			expected: "<div><pre>  keep\n   <b>this</b>\n</pre></div>",
+		},
+		{
+			input:    "<script>\n  if (a  <  b) {}\n</script>\n<textarea>\n  text  </textarea>",
+			expected: "<script>\n  if (a  <  b) {}\n</script><textarea>\n  text  </textarea>",
+		},
+		{
+			input:    "1  <  2",
+			expected: "1 < 2",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result := string(minifyHTML([]byte(tt.input)))
+			if result != tt.expected {
+				t.Errorf("minifyHTML(%q) = %q, expected %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package mold
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Handler implements Engine.
+func (m *moldEngine) Handler(resolver func(*http.Request) (string, any, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		view, data, err := resolver(r)
+		if err != nil {
+			m.writeError(w, err)
+			return
+		}
+
+		status := http.StatusOK
+		if m.notFound(view) {
+			view, status = m.c.notFoundView.val, http.StatusNotFound
+		}
+
+		buf := m.buffers.get()
+		defer m.buffers.put(buf)
+		if err := m.Render(buf, view, data); err != nil {
+			m.writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", m.ContentType(view))
+		w.WriteHeader(status)
+		_, _ = buf.WriteTo(w)
+	})
+}
+
+// RenderGzip implements Engine.
+func (m *moldEngine) RenderGzip(w http.ResponseWriter, r *http.Request, view string, data any) error {
+	status := http.StatusOK
+	if m.notFound(view) {
+		view, status = m.c.notFoundView.val, http.StatusNotFound
+	}
+
+	buf := m.buffers.get()
+	defer m.buffers.put(buf)
+	if err := m.Render(buf, view, data); err != nil {
+		return err
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", m.ContentType(view))
+	h.Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		w.WriteHeader(status)
+		_, err := buf.WriteTo(w)
+		return err
+	}
+
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	w.WriteHeader(status)
+	gz := gzip.NewWriter(w)
+	if _, err := buf.WriteTo(gz); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// RenderRequest implements Engine.
+func (m *moldEngine) RenderRequest(w http.ResponseWriter, r *http.Request, view string, data any) error {
+	status := http.StatusOK
+	if m.notFound(view) {
+		view, status = m.c.notFoundView.val, http.StatusNotFound
+	}
+
+	buf := m.buffers.get()
+	defer m.buffers.put(buf)
+	if err := m.RenderWith(buf, view, data, map[string]any{"request": r}); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", m.ContentType(view))
+	w.WriteHeader(status)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// isActive reports whether the path of the request is the link or below it, by path segments.
+// The root link "/" is only active for the root path.
+func isActive(r *http.Request, link string) bool {
+	if r == nil || link == "" {
+		return false
+	}
+	if link == "/" {
+		return r.URL.Path == "/"
+	}
+	link = strings.TrimSuffix(link, "/")
+	return r.URL.Path == link || strings.HasPrefix(r.URL.Path, link+"/")
+}
+
+// RenderBytes implements Engine.
+func (m *moldEngine) RenderBytes(view string, data any) ([]byte, error) {
+	if m.buffers == nil {
+		var buf bytes.Buffer
+		if err := m.Render(&buf, view, data); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	// the output outlives the recycled buffer
+	buf := m.buffers.get()
+	defer m.buffers.put(buf)
+	if err := m.Render(buf, view, data); err != nil {
+		return nil, err
+	}
+	return bytes.Clone(buf.Bytes()), nil
+}
+
+// RenderHTML implements Engine.
+func (m *moldEngine) RenderHTML(view string, data any) (template.HTML, error) {
+	if m.c.textMode.val {
+		return "", errors.New("rendering as HTML not supported in text mode")
+	}
+
+	out, err := m.RenderBytes(view, data)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(out), nil
+}
+
+// RenderWithETag implements Engine.
+func (m *moldEngine) RenderWithETag(view string, data any) ([]byte, string, error) {
+	out, err := m.RenderBytes(view, data)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(out)
+	return out, `"` + hex.EncodeToString(sum[:]) + `"`, nil
+}
+
+// RenderTee implements Engine.
+func (m *moldEngine) RenderTee(view string, data any, writers ...io.Writer) error {
+	out, err := m.RenderBytes(view, data)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range writers {
+		if _, werr := w.Write(out); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// RenderStream implements Engine.
+func (m *moldEngine) RenderStream(w io.Writer, view string, data any) error {
+	if f, ok := w.(http.Flusher); ok {
+		w = &flushWriter{Writer: w, flusher: f}
+	}
+	return m.Render(w, view, data)
+}
+
+// flushWriter flushes once the closing head tag is written.
+type flushWriter struct {
+	io.Writer
+	flusher http.Flusher
+	flushed bool
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.Writer.Write(p)
+	if err == nil && !f.flushed && indexFold(p, []byte("</head>")) >= 0 {
+		f.flushed = true
+		f.flusher.Flush()
+	}
+	return n, err
+}
+
+// ContentType implements Engine.
+func (m *moldEngine) ContentType(view string) string {
+	view = m.target(view)
+	m.mu.Lock()
+	t, ok := m.set[view]
+	m.mu.Unlock()
+
+	switch {
+	case !ok:
+		return ""
+	case t.contentType != "":
+		return t.contentType
+	case m.c.textMode.val:
+		return "text/plain; charset=utf-8"
+	default:
+		return "text/html; charset=utf-8"
+	}
+}
+
+// parseContentType returns the content type declared in a leading comment of the template body.
+//
+//	{{/* content-type: application/xml */}}
+func parseContentType(body string) string {
+	return parseDirective(body, "content-type")
+}
+
+// acceptsGzip reports whether the client accepts gzip encoded responses.
+func acceptsGzip(r *http.Request) bool {
+	for _, header := range r.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(header, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			name = strings.TrimSpace(name)
+			if name != "gzip" && name != "*" {
+				continue
+			}
+			// a quality value of 0 means not acceptable
+			q := strings.ReplaceAll(params, " ", "")
+			if q == "q=0" || strings.HasPrefix(q, "q=0.") && strings.Trim(q[4:], "0") == "" {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// writeError writes the HTTP status corresponding to the error, see [WithStatusMapper].
+func (m *moldEngine) writeError(w http.ResponseWriter, err error) {
+	code := 0
+	if m.c.statusMapper.set {
+		code = m.c.statusMapper.val(err)
+	}
+	if code == 0 {
+		code = http.StatusInternalServerError
+		if errors.Is(err, ErrNotFound) {
+			code = http.StatusNotFound
+		}
+	}
+	http.Error(w, http.StatusText(code), code)
+}
@@ -0,0 +1,496 @@
+package mold
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", "{{render}}"},
+		testFile{"about.html", "About {{.}}"},
+		testFile{"error.html", "{{.Missing}}"},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	handler := engine.Handler(func(r *http.Request) (string, any, error) {
+		if r.URL.Path == "/forbidden" {
+			return "", nil, errors.New("forbidden")
+		}
+		return strings.TrimPrefix(r.URL.Path, "/") + ".html", "Mold", nil
+	})
+
+	tests := []struct {
+		path         string
+		expectedCode int
+		expectedBody string
+	}{
+		{path: "/about", expectedCode: http.StatusOK, expectedBody: "About Mold"},
+		{path: "/missing", expectedCode: http.StatusNotFound, expectedBody: "Not Found\n"},
+		{path: "/error", expectedCode: http.StatusInternalServerError, expectedBody: "Internal Server Error\n"},
+		{path: "/forbidden", expectedCode: http.StatusInternalServerError, expectedBody: "Internal Server Error\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.expectedCode {
+				t.Errorf("ServeHTTP() code = %d, want %d", rec.Code, tt.expectedCode)
+			}
+			if rec.Body.String() != tt.expectedBody {
+				t.Errorf("ServeHTTP() body = %q, want %q", rec.Body.String(), tt.expectedBody)
+			}
+		})
+	}
+}
+
+func TestHandler_StatusMapper(t *testing.T) {
+	testFS := createTestFS(testFile{"layout.html", "{{render}}"})
+	errForbidden := errors.New("forbidden")
+
+	engine := Must(New(testFS, WithLayout("layout.html"), WithStatusMapper(func(err error) int {
+		if errors.Is(err, errForbidden) {
+			return http.StatusForbidden
+		}
+		return 0
+	})))
+
+	handler := engine.Handler(func(r *http.Request) (string, any, error) {
+		switch r.URL.Path {
+		case "/forbidden":
+			return "", nil, fmt.Errorf("error resolving view: %w", errForbidden)
+		case "/deleted":
+			return "", nil, fmt.Errorf("error resolving view: %w", ErrNotFound)
+		}
+		return strings.TrimPrefix(r.URL.Path, "/") + ".html", nil, nil
+	})
+
+	tests := []struct {
+		path         string
+		expectedCode int
+	}{
+		{path: "/forbidden", expectedCode: http.StatusForbidden},
+		{path: "/deleted", expectedCode: http.StatusNotFound},
+		{path: "/missing", expectedCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.expectedCode {
+				t.Errorf("ServeHTTP() code = %d, want %d", rec.Code, tt.expectedCode)
+			}
+		})
+	}
+
+	if _, err := New(testFS, WithStatusMapper(nil)); err == nil {
+		t.Errorf("New() expected error, got nil")
+	}
+}
+
+func TestHandler_NotFoundView(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", "{{render}}"},
+		testFile{"404.html", "Not Found {{.}}"},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html"), WithNotFoundView("404.html")))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "missing.html", "Mold"); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := "Not Found Mold"; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	handler := engine.Handler(func(r *http.Request) (string, any, error) {
+		return "missing.html", "Mold", nil
+	})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if rec.Code != http.StatusNotFound || rec.Body.String() != "Not Found Mold" {
+		t.Errorf("ServeHTTP() got = %d %q", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("ServeHTTP() Content-Type = %q", ct)
+	}
+
+	// missing fallback view
+	engine = Must(New(testFS, WithLayout("layout.html"), WithNotFoundView("missing.html")))
+	if err := engine.Render(io.Discard, "missing.html", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Render() expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRenderGzip(t *testing.T) {
+	testFS := createTestFS(testFile{"layout.html", "{{render}}"})
+
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	data := map[string]any{"Name": "John Doe", "Location": "Mars"}
+	expected := "Hello, John Doe!<br>Location: Mars"
+
+	tests := []struct {
+		acceptEncoding string
+		gzip           bool
+	}{
+		{acceptEncoding: "", gzip: false},
+		{acceptEncoding: "gzip", gzip: true},
+		{acceptEncoding: "deflate, gzip;q=1.0, *;q=0.5", gzip: true},
+		{acceptEncoding: "br, *", gzip: true},
+		{acceptEncoding: "gzip;q=0", gzip: false},
+		{acceptEncoding: "deflate, gzip; q=0.000", gzip: false},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rec := httptest.NewRecorder()
+
+			if err := engine.RenderGzip(rec, r, "view.html", data); err != nil {
+				t.Fatalf("RenderGzip() error = %v", err)
+			}
+
+			body := rec.Body.String()
+			if tt.gzip {
+				if rec.Header().Get("Content-Encoding") != "gzip" {
+					t.Fatalf("RenderGzip() expected gzip content encoding")
+				}
+				gz, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatalf("gzip.NewReader() error = %v", err)
+				}
+				b, _ := io.ReadAll(gz)
+				body = string(b)
+			} else if rec.Header().Get("Content-Encoding") != "" {
+				t.Errorf("RenderGzip() expected no content encoding")
+			}
+
+			if body != expected {
+				t.Errorf("RenderGzip() got = %q, want %q", body, expected)
+			}
+		})
+	}
+}
+
+func TestRenderGzip_Error(t *testing.T) {
+	engine := Must(New(createTestFS()))
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := engine.RenderGzip(rec, r, "missing.html", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RenderGzip() expected ErrNotFound, got %v", err)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("RenderGzip() expected empty body, got %q", rec.Body.String())
+	}
+}
+
+func TestContentType(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"sitemap.html", "\n{{/* content-type: application/xml */}}\n<urlset></urlset>"},
+		testFile{"feed.html", `{{- /* Content-Type: application/rss+xml; charset=utf-8 */ -}}<rss></rss>`},
+		testFile{"comment.html", `{{/* a comment */}}<p></p>`},
+	)
+
+	engine := Must(New(testFS))
+
+	tests := []struct {
+		view     string
+		expected string
+	}{
+		{view: "sitemap.html", expected: "application/xml"},
+		{view: "feed.html", expected: "application/rss+xml; charset=utf-8"},
+		{view: "comment.html", expected: "text/html; charset=utf-8"},
+		{view: "view.html", expected: "text/html; charset=utf-8"},
+		{view: "missing.html", expected: ""},
+	}
+
+	for _, tt := range tests {
+		if got := engine.ContentType(tt.view); got != tt.expected {
+			t.Errorf("ContentType(%q) = %q, want %q", tt.view, got, tt.expected)
+		}
+	}
+
+	handler := engine.Handler(func(r *http.Request) (string, any, error) {
+		return "sitemap.html", nil, nil
+	})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("Content-Type"); got != "application/xml" {
+		t.Errorf("ServeHTTP() Content-Type = %q, want %q", got, "application/xml")
+	}
+}
+
+type flushRecorder struct {
+	bytes.Buffer
+	flushedAt []int
+}
+
+func (f *flushRecorder) Flush() {
+	f.flushedAt = append(f.flushedAt, f.Len())
+}
+
+func TestRenderStream(t *testing.T) {
+	engine := Must(New(createTestFS(testFile{"view.html", `{{define "head"}}<title>Mold</title>{{end}}<p>Hello</p>`})))
+
+	var w flushRecorder
+	if err := engine.RenderStream(&w, "view.html", nil); err != nil {
+		t.Fatalf("RenderStream() error = %v", err)
+	}
+
+	head := strings.Index(w.String(), "</head>") + len("</head>")
+	if len(w.flushedAt) != 1 || w.flushedAt[0] < head || w.flushedAt[0] > strings.Index(w.String(), "<p>") {
+		t.Errorf("RenderStream() flushed at %v, expected once after the head", w.flushedAt)
+	}
+	if !strings.Contains(w.String(), "<title>Mold</title>") || !strings.Contains(w.String(), "<p>Hello</p>") {
+		t.Errorf("RenderStream() got = %q", w.String())
+	}
+}
+
+func TestRenderWithETag(t *testing.T) {
+	engine := Must(New(createTestFS(testFile{"layout.html", "{{render}}"}), WithLayout("layout.html")))
+	data := map[string]any{"Name": "John Doe", "Location": "Mars"}
+
+	out, err := engine.RenderBytes("view.html", data)
+	if err != nil {
+		t.Fatalf("RenderBytes() error = %v", err)
+	}
+	if expected := "Hello, John Doe!<br>Location: Mars"; string(out) != expected {
+		t.Errorf("RenderBytes() got = %q, want %q", out, expected)
+	}
+
+	out2, etag, err := engine.RenderWithETag("view.html", data)
+	if err != nil {
+		t.Fatalf("RenderWithETag() error = %v", err)
+	}
+	if !bytes.Equal(out, out2) {
+		t.Errorf("RenderWithETag() got = %q, want %q", out2, out)
+	}
+	if len(etag) != 66 || etag[0] != '"' || etag[65] != '"' {
+		t.Errorf("RenderWithETag() etag = %s, expected a quoted SHA-256 hash", etag)
+	}
+
+	// the ETag changes with the output only
+	_, same, _ := engine.RenderWithETag("view.html", data)
+	_, other, _ := engine.RenderWithETag("view.html", map[string]any{"Name": "Jane Doe", "Location": "Mars"})
+	if same != etag || other == etag {
+		t.Errorf("RenderWithETag() etags = %s, %s, %s", etag, same, other)
+	}
+
+	if _, _, err := engine.RenderWithETag("missing.html", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RenderWithETag() expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	testFS := createTestFS(testFile{"layout.html", "{{render}}"}, testFile{"widget.html", "<b>{{.}}</b>"})
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	out, err := engine.RenderHTML("widget.html", "<i>")
+	if err != nil {
+		t.Fatalf("RenderHTML() error = %v", err)
+	}
+
+	// the output is not escaped again when embedded into another template
+	var buf bytes.Buffer
+	page := template.Must(template.New("page").Parse(`<div>{{.}}</div>`))
+	if err := page.Execute(&buf, out); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if expected := "<div><b>&lt;i&gt;</b></div>"; buf.String() != expected {
+		t.Errorf("RenderHTML() embedded got = %q, want %q", buf.String(), expected)
+	}
+
+	if _, err := engine.RenderHTML("missing.html", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RenderHTML() expected ErrNotFound, got %v", err)
+	}
+
+	textEngine := Must(New(testFS, WithLayout("layout.html"), WithTextMode(true)))
+	if _, err := textEngine.RenderHTML("widget.html", nil); err == nil {
+		t.Errorf("RenderHTML() expected error in text mode, got nil")
+	}
+}
+
+type failingWriter struct{ err error }
+
+func (w failingWriter) Write([]byte) (int, error) { return 0, w.err }
+
+func TestRenderTee(t *testing.T) {
+	testFS := createTestFS(testFile{"layout.html", "{{render}}"}, testFile{"widget.html", "<b>{{.}}</b>"})
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	var a, b bytes.Buffer
+	errWrite := errors.New("write failed")
+	err := engine.RenderTee("widget.html", "OK", &a, failingWriter{err: errWrite}, &b, failingWriter{err: io.ErrShortWrite})
+	if !errors.Is(err, errWrite) {
+		t.Errorf("RenderTee() error = %v, want %v", err, errWrite)
+	}
+	for _, buf := range []*bytes.Buffer{&a, &b} {
+		if expected := "<b>OK</b>"; buf.String() != expected {
+			t.Errorf("RenderTee() got = %q, want %q", buf.String(), expected)
+		}
+	}
+
+	a.Reset()
+	if err := engine.RenderTee("missing.html", nil, &a); !errors.Is(err, ErrNotFound) || a.Len() > 0 {
+		t.Errorf("RenderTee() error = %v, output = %q, expected ErrNotFound and no output", err, a.String())
+	}
+}
+
+func TestRenderBytes_BufferPool(t *testing.T) {
+	testFS := createTestFS(testFile{"layout.html", "{{render}}"}, testFile{"widget.html", "<b>{{.}}</b>"})
+	engine := Must(New(testFS, WithLayout("layout.html"), WithBufferPool(64)))
+
+	// the output is not reused by later renders
+	first, err := engine.RenderBytes("widget.html", "first")
+	if err != nil {
+		t.Fatalf("RenderBytes() error = %v", err)
+	}
+	if _, err := engine.RenderBytes("widget.html", "second"); err != nil {
+		t.Fatalf("RenderBytes() error = %v", err)
+	}
+	if expected := "<b>first</b>"; string(first) != expected {
+		t.Errorf("RenderBytes() got = %q, want %q", first, expected)
+	}
+
+	if _, err := New(testFS, WithBufferPool(-1)); err == nil {
+		t.Errorf("New() expected error, got nil")
+	}
+}
+
+// BenchmarkHandler_BufferPool compares the allocations of buffered renders of a typical page,
+// with buffers allocated per render and recycled buffers pre-sized to the page.
+func BenchmarkHandler_BufferPool(b *testing.B) {
+	testFS := createTestFS(
+		testFile{"layout.html", "<main>{{render}}</main>"},
+		testFile{"list.html", `<ul>{{range .}}<li class="item"><a href="/items/{{.}}">Item {{.}}</a></li>{{end}}</ul>`},
+	)
+	items := make([]int, 500) // ~25 KiB of output
+	for i := range items {
+		items[i] = i
+	}
+
+	for name, options := range map[string][]Option{
+		"unpooled": nil,
+		"pooled":   {WithBufferPool(32 << 10)},
+	} {
+		b.Run(name, func(b *testing.B) {
+			engine := Must(New(testFS, append(options, WithLayout("layout.html"))...))
+			handler := engine.Handler(func(*http.Request) (string, any, error) { return "list.html", items, nil })
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			b.ReportAllocs()
+			for range b.N {
+				handler.ServeHTTP(discardResponseWriter{}, r)
+			}
+		})
+	}
+}
+
+// discardResponseWriter discards the response, not to measure the allocations of a recorder.
+type discardResponseWriter struct{}
+
+func (discardResponseWriter) Header() http.Header         { return http.Header{} }
+func (discardResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (discardResponseWriter) WriteHeader(int)             {}
+
+func TestRenderRequest(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", "{{render}}"},
+		testFile{"nav.html", `{{currentPath}}|{{query "q"}}|{{isActive "/"}} {{isActive "/blog"}} {{isActive "/blog/"}} {{isActive "/blo"}} {{isActive "/about"}}`},
+	)
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/blog/post?q=a%26b", nil)
+	if err := engine.RenderRequest(rec, r, "nav.html", nil); err != nil {
+		t.Fatalf("RenderRequest() error = %v", err)
+	}
+	if expected := "/blog/post|a&amp;b|false true true false false"; rec.Body.String() != expected {
+		t.Errorf("RenderRequest() got = %q, want %q", rec.Body.String(), expected)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want text/html", ct)
+	}
+
+	// without a request
+	out, err := engine.RenderBytes("nav.html", nil)
+	if err != nil {
+		t.Fatalf("RenderBytes() error = %v", err)
+	}
+	if expected := "||false false false false false"; string(out) != expected {
+		t.Errorf("RenderBytes() got = %q, want %q", out, expected)
+	}
+
+	rec = httptest.NewRecorder()
+	if err := engine.RenderRequest(rec, r, "missing.html", nil); !errors.Is(err, ErrNotFound) || rec.Body.Len() > 0 {
+		t.Errorf("RenderRequest() error = %v, body = %q, expected ErrNotFound and no output", err, rec.Body.String())
+	}
+}
+
+func TestHideFS_FileServer(t *testing.T) {
+	dir := t.TempDir()
+	for name, body := range map[string]string{"notes": "plain text notes", "style.css": "body {}", "view.html": "{{.}}"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := http.FileServerFS(HideFS(os.DirFS(dir)))
+
+	tests := []struct {
+		path         string
+		rangeHeader  string
+		expectedCode int
+		expectedBody string
+	}{
+		// the content type of files without a known extension is sniffed, which requires seeking
+		{path: "/notes", expectedCode: http.StatusOK, expectedBody: "plain text notes"},
+		{path: "/notes", rangeHeader: "bytes=6-9", expectedCode: http.StatusPartialContent, expectedBody: "text"},
+		{path: "/style.css", rangeHeader: "bytes=0-3", expectedCode: http.StatusPartialContent, expectedBody: "body"},
+		{path: "/view.html", expectedCode: http.StatusNotFound, expectedBody: "404 page not found\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.rangeHeader, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.rangeHeader != "" {
+				r.Header.Set("Range", tt.rangeHeader)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			if rec.Code != tt.expectedCode {
+				t.Errorf("ServeHTTP() code = %d, want %d", rec.Code, tt.expectedCode)
+			}
+			if rec.Body.String() != tt.expectedBody {
+				t.Errorf("ServeHTTP() body = %q, want %q", rec.Body.String(), tt.expectedBody)
+			}
+		})
+	}
+
+	// directory listings omit hidden files
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "style.css") || strings.Contains(body, "view.html") {
+		t.Errorf("ServeHTTP() listing = %q, expected style.css without view.html", body)
+	}
+}
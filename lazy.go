@@ -0,0 +1,69 @@
+package mold
+
+import (
+	"reflect"
+	"sync"
+)
+
+// LazyValue is a value computed on first use, see [Lazy].
+type LazyValue[T any] struct {
+	once sync.Once
+	fn   func() (T, error)
+	val  T
+	err  error
+}
+
+// Lazy returns a value computed by fn on first use, for data that is expensive to compute and only
+// needed by some views or conditional branches. It is computed at most once, even if shared by concurrent
+// renders, and is accessed in templates with the "lazy" function or the Value method.
+//
+//	data := map[string]any{
+//	    "Comments": mold.Lazy(func() ([]Comment, error) { return db.Comments(id) }),
+//	}
+//
+//	{{if .ShowComments}}{{range lazy .Comments}}...{{end}}{{end}}
+func Lazy[T any](fn func() (T, error)) *LazyValue[T] {
+	return &LazyValue[T]{fn: fn}
+}
+
+// Value returns the value, computing it on first call.
+func (l *LazyValue[T]) Value() (T, error) {
+	l.once.Do(func() { l.val, l.err = l.fn() })
+	return l.val, l.err
+}
+
+func (l *LazyValue[T]) lazyValue() (any, error) {
+	return l.Value()
+}
+
+// lazyValuer is implemented by all instances of [LazyValue].
+type lazyValuer interface {
+	lazyValue() (any, error)
+}
+
+var errorType = reflect.TypeFor[error]()
+
+// lazy returns the value of v if computed lazily, see [Lazy], or the result of calling v if it is
+// a function without arguments, returning a value and optionally an error. Unlike values created
+// with Lazy, functions are called on every use. Other values are returned as is.
+func lazy(v any) (any, error) {
+	if l, ok := v.(lazyValuer); ok {
+		return l.lazyValue()
+	}
+
+	fn := reflect.ValueOf(v)
+	if fn.Kind() != reflect.Func || fn.IsNil() {
+		return v, nil
+	}
+	switch t := fn.Type(); {
+	case t.NumIn() != 0:
+		return v, nil
+	case t.NumOut() == 1:
+		return fn.Call(nil)[0].Interface(), nil
+	case t.NumOut() == 2 && t.Out(1) == errorType:
+		out := fn.Call(nil)
+		err, _ := out[1].Interface().(error)
+		return out[0].Interface(), err
+	}
+	return v, nil
+}
@@ -0,0 +1,42 @@
+package mold
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"strings"
+)
+
+// markdownExt is the filename extension of Markdown partials.
+const markdownExt = ".md"
+
+// markdownFunc is the function Markdown partial declarations are swapped with.
+const markdownFunc = "_markdown"
+
+// isMarkdown reports whether the file is a Markdown partial.
+func isMarkdown(c *Config, name string) bool {
+	return c.markdown.set && strings.EqualFold(filepath.Ext(name), markdownExt)
+}
+
+// markdownFuncs returns the function executing the Markdown partials of the set.
+// It is bound to each assembled view, the set being specific to the build of the view.
+func markdownFuncs(set templateSet, renderer func([]byte) ([]byte, error)) template.FuncMap {
+	return map[string]any{
+		markdownFunc: func(name string, data any) (template.HTML, error) {
+			f := set[name]
+			if f == nil || f.markdownTmpl == nil {
+				return "", fmt.Errorf("error rendering markdown '%s': %w", name, ErrNotFound)
+			}
+			var buf bytes.Buffer
+			if err := f.markdownTmpl.Execute(&buf, data); err != nil {
+				return "", err
+			}
+			out, err := renderer(buf.Bytes())
+			if err != nil {
+				return "", fmt.Errorf("error rendering markdown '%s': %w", name, err)
+			}
+			return template.HTML(out), nil
+		},
+	}
+}
@@ -1,11 +1,17 @@
 package mold
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
-	"path/filepath"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
 )
 
 // Engine represents a web page renderer, incorporating a specific layout.
@@ -14,6 +20,10 @@ import (
 //
 // The layout defines the overall page structure,
 // while views provide the dynamic content.
+//
+// An Engine is safe for concurrent use by multiple goroutines.
+// Views are assembled once and never mutated afterwards, [Engine.Reload] replaces
+// the assembled view atomically, renders in progress complete with the previous version.
 type Engine interface {
 	// Render executes the layout template, merging it with the specified view template,
 	// then writes the resulting HTML to the provided io.Writer.
@@ -26,28 +36,376 @@ type Engine interface {
 	// Returns:
 	//   An error, if any, that occurred during template execution or while writing to the writer.
 	Render(w io.Writer, view string, data any) error
+
+	// DebugTree returns a human-readable dump of the fully assembled template for the view,
+	// after the layout, sections and partials have been merged.
+	// Each template is printed as a "define" block, starting with the layout.
+	// The trees are printed as assembled, before they are escaped by html/template on execution.
+	//
+	// It is intended as a development aid and the output format is not guaranteed to be stable.
+	DebugTree(view string) (string, error)
+
+	// Reload re-reads the view file from the filesystem, parses it and
+	// replaces the assembled template for the view.
+	//
+	// The partials referenced by the view, directly or through other partials, are re-read as well.
+	// Other views referencing the same partials are not affected until they are reloaded.
+	// The layout is never reloaded, a new Engine is required for changes to the layout.
+	//
+	// If an error occurs, the previously assembled template remains in use.
+	Reload(view string) error
+
+	// Handler returns an [http.Handler] that renders views in response to requests.
+	// The resolver maps each request to the view to render and its data.
+	//
+	// The output is buffered, a failed render does not result in a partially written response.
+	// A status of 404 is written when the view does not exist or the resolver returns an error
+	// wrapping [ErrNotFound], and 500 for any other error, unless mapped otherwise with [WithStatusMapper].
+	//
+	// Example:
+	//
+	//	http.Handle("/", engine.Handler(func(r *http.Request) (string, any, error) {
+	//	    return path.Join("pages", r.URL.Path) + ".html", nil, nil
+	//	}))
+	Handler(resolver func(*http.Request) (view string, data any, err error)) http.Handler
+
+	// RenderGzip is like Render but writes the output to the response compressed with gzip,
+	// if accepted by the client as indicated by the "Accept-Encoding" header of the request.
+	// The "Content-Type", "Content-Encoding" and "Vary" headers are set accordingly.
+	//
+	// The output is buffered, nothing is written to the response if the render fails.
+	RenderGzip(w http.ResponseWriter, r *http.Request, view string, data any) error
+
+	// RenderRequest is like Render but writes the output to the response, with the request accessible
+	// to the "currentPath", "query" and "isActive" template functions, e.g. for navigation highlighting.
+	// It is equivalent to RenderWith with the request as the "request" value.
+	// The "Content-Type" header is set according to the view, see [Engine.ContentType].
+	//
+	//	<a href="/about" {{if isActive "/about"}}class="active"{{end}}>About</a>
+	//	<input name="q" value="{{query "q"}}">
+	//
+	// The output is buffered, nothing is written to the response if the render fails.
+	RenderRequest(w http.ResponseWriter, r *http.Request, view string, data any) error
+
+	// Template returns the fully assembled template for the view, as executed by Render.
+	// The returned template is a distinct copy, modifying it does not affect the Engine.
+	//
+	// The template is always an html/template, regardless of [WithTextMode].
+	Template(view string) (*template.Template, error)
+
+	// Validate re-reads all template files from the filesystem and reports every error
+	// that would prevent a view from being rendered, e.g. invalid syntax or references to
+	// missing partials. The Engine itself is not modified, see [Engine.Reload].
+	//
+	// The layout is not re-read, a new Engine is required for changes to the layout.
+	Validate() error
+
+	// ContentType returns the content type of the view, as used by [Engine.Handler] and [Engine.RenderGzip].
+	// A view may declare its content type with a leading comment, otherwise it defaults to
+	// "text/html; charset=utf-8", or "text/plain; charset=utf-8" with [WithTextMode].
+	// An empty string is returned if the view does not exist.
+	//
+	//	{{/* content-type: application/xml */}}
+	ContentType(view string) string
+
+	// HideFS returns the filesystem of the engine, relative to the root, with the template files hidden.
+	// It is equivalent to the package level [HideFS] with exactly the filename extensions parsed by the engine.
+	//
+	//	http.Handle("/static/", http.FileServerFS(engine.HideFS()))
+	HideFS() fs.FS
+
+	// RenderStream is like Render but flushes the output early, if w implements [http.Flusher].
+	// The output is flushed once the closing "</head>" tag of the layout is written, allowing
+	// the client to fetch stylesheets and scripts while the rest of the page is rendered.
+	//
+	// Once flushed, an error that occurs later in the render cannot be recovered from
+	// e.g. by responding with an error status, as the status and part of the page are already sent.
+	RenderStream(w io.Writer, view string, data any) error
+
+	// RenderAll renders every view to a file at the same path under dir, creating directories as needed.
+	// This is useful to generate a static site. Partials, i.e. templates referenced with the
+	// "partial" function, are not rendered.
+	//
+	// The data for each view is provided by dataFor, which may be nil.
+	// Errors are collected and reported for all views at once.
+	RenderAll(dir string, dataFor func(view string) any) error
+
+	// Dependencies returns the sorted paths of the partials the view depends on, transitively,
+	// including the partials referenced by the layout.
+	// This is useful to determine the views to reload when a partial changes.
+	Dependencies(view string) ([]string, error)
+
+	// Graph returns the composition of each view, as rendered by [Engine.RenderAll], for documentation
+	// and visualization. Each view maps to the layouts wrapping it, from the outermost to the innermost,
+	// followed by the partials it references directly and the sections it defines, both sorted.
+	// Entries are prefixed with their kind, e.g.
+	//
+	//	"index.html": {"layout:layout.html", "partial:nav.html", "section:head"}
+	Graph() (map[string][]string, error)
+
+	// Stats returns the number of layouts, views and partials of the engine and the size of their templates,
+	// e.g. for capacity planning or to catch templates included by accident.
+	Stats() Stats
+
+	// Source returns the body of the template at the path, as read from the filesystem or registered with
+	// [WithPartial], e.g. to preview the source of templates. It reports false if there is no such view,
+	// partial or layout. Layouts declared by views with the "layouts" directive are not retained.
+	Source(name string) (string, bool)
+
+	// RenderSection executes only the named template of the assembled view, e.g. a section defined
+	// by the view, without the layout. [ErrNotFound] is returned if the section does not exist.
+	//
+	//	engine.RenderSection(w, "email.html", "email_body", data)
+	RenderSection(w io.Writer, view, section string, data any) error
+
+	// RenderWithLayout is like Render but wraps the view with the layout file chosen at render time,
+	// in place of the configured layout and the layouts declared by the view.
+	// The view is assembled with the layout on first use and cached for subsequent renders.
+	// The error wraps [ErrNotFound] if the view or the layout does not exist.
+	//
+	//	layout := "layout.html"
+	//	if r.URL.Query().Get("print") == "1" {
+	//	    layout = "print_layout.html"
+	//	}
+	//	engine.RenderWithLayout(w, layout, "article.html", data)
+	RenderWithLayout(w io.Writer, layout, view string, data any) error
+
+	// RenderWithOverrides is like Render but renders partials in place of others for this render only,
+	// e.g. to experiment with variants of a partial, wherever referenced by the view, its layout or partials.
+	// The view is assembled with the overrides on first use and cached for subsequent renders.
+	//
+	//	engine.RenderWithOverrides(w, "index.html", data, mold.Overrides{"card.html": "card_v2.html"})
+	RenderWithOverrides(w io.Writer, view string, data any, overrides Overrides) error
+
+	// RenderWith is like Render but with per-render values, accessible to built-in template functions.
+	// This is useful for values specific to a request that cannot be captured by functions
+	// configured with [WithFuncMap], which are shared by all renders.
+	//
+	// The "nonce" value is returned by the "nonce" function, e.g. for a Content Security Policy.
+	// The "locale" value is the locale of messages translated by the "t" function, see [WithTranslator].
+	// The "request" value is the [*http.Request] used by the "currentPath", "query" and "isActive"
+	// functions, see [Engine.RenderRequest].
+	// Outside of RenderWith, the values are empty strings, and "isActive" reports false.
+	//
+	//	engine.RenderWith(w, "view.html", data, map[string]any{"nonce": nonce, "locale": "de"})
+	//
+	//	<script nonce="{{nonce}}">...</script>
+	//
+	// The values are bound before the view is executed, so they are unaffected by buffering
+	// for [WithMinify] or [WithStripComments]. However, the output of partials memoized with
+	// "cachedPartial" is shared by renders, and must not depend on per-render values.
+	RenderWith(w io.Writer, view string, data any, values map[string]any) error
+
+	// RenderBytes is like Render but returns the output.
+	RenderBytes(view string, data any) ([]byte, error)
+
+	// RenderHTML is like RenderBytes but returns the output as [template.HTML],
+	// for embedding into templates of other engines without being escaped again.
+	// It returns an error with [WithTextMode], as the output is not escaped.
+	//
+	//	content, err := engine.RenderHTML("widget.html", data)
+	//	page.Execute(w, map[string]any{"Widget": content})
+	RenderHTML(view string, data any) (template.HTML, error)
+
+	// RenderWithETag is like RenderBytes but also returns a strong ETag of the output,
+	// the quoted hex encoded SHA-256 hash, for conditional requests.
+	//
+	//	out, etag, err := engine.RenderWithETag("view.html", data)
+	//	if r.Header.Get("If-None-Match") == etag {
+	//	    w.WriteHeader(http.StatusNotModified)
+	//	    return
+	//	}
+	//	w.Header().Set("ETag", etag)
+	RenderWithETag(view string, data any) ([]byte, string, error)
+
+	// RenderTee is like Render but writes the output to all the writers, e.g. the response and a log file.
+	// The view is rendered once, nothing is written if rendering fails. All the writers are written to,
+	// the first write error is returned.
+	RenderTee(view string, data any, writers ...io.Writer) error
+
+	// RenderText is like Render but with a view parsed from the template body, e.g. stored in a database,
+	// rather than a file. The view may render partials and use functions as any other view.
+	// It is parsed on every call and not cached.
+	//
+	//	engine.RenderText(w, `<p>Hello {{.Name}}</p>{{partial "signature.html" .}}`, data)
+	RenderText(w io.Writer, body string, data any) error
+
+	// Has reports whether the view exists, i.e. it would be found by Render, without rendering it.
+	// Aliases registered with [WithAlias] are views, as are partials, which Render accepts.
+	// It therefore reports true for paths not listed by [Engine.Views].
+	Has(view string) bool
+
+	// Views returns the sorted paths of the views, as rendered by [Engine.RenderAll].
+	// Partials, i.e. templates referenced with the "partial" function, and aliases are not included,
+	// although reported by [Engine.Has].
+	Views() []string
+
+	// Close stops any background goroutines, e.g. watching the filesystem with [WithWatch],
+	// and releases the cached views and partials. It is always safe to call, more than once,
+	// and a no-op for an Engine without background work.
+	//
+	// Views are assembled again if rendered after Close, but the filesystem is no longer watched.
+	//
+	//	engine := mold.Must(mold.New(fs))
+	//	defer engine.Close()
+	Close() error
 }
 
 // Config is the configuration for a new [Engine].
 type Config struct {
 	fs        fs.FS
 	layoutRaw string
+	partials  map[string]string
+	aliases   map[string]string
+
+	partialResolver optionVal[PartialResolver]
+
+	viewFuncMaps map[string]template.FuncMap
 
 	// options
-	root    optionVal[string]
-	layout  optionVal[string]
-	exts    optionVal[[]string]
-	funcMap optionVal[template.FuncMap]
+	root          optionVal[string]
+	themes        optionVal[[]string]
+	layout        optionVal[string]
+	layoutReader  optionVal[io.Reader]
+	exts          optionVal[[]string]
+	funcMap       optionVal[template.FuncMap]
+	include       optionVal[[]string]
+	exclude       optionVal[[]string]
+	standalone    optionVal[[]string]
+	includeHidden optionVal[bool]
+	caseFold      optionVal[bool]
+
+	// userFuncs are the functions configured with [WithFuncMap], before built-in functions are added.
+	userFuncs template.FuncMap
+	// renderFuncs are the functions depending on the render, bound for each render,
+	// see [Engine.RenderWith] and [WithFuncMapFactory].
+	renderFuncs template.FuncMap
+
+	partialFuncMap optionVal[template.FuncMap]
+	funcMapFactory optionVal[func(RenderContext) template.FuncMap]
+	globals        optionVal[map[string]any]
+	translator     optionVal[func(locale, key string, args ...any) string]
+	sanitizer      optionVal[func(string) string]
+
+	templateOptions optionVal[[]string]
+	baseTemplate    optionVal[*template.Template]
+
+	stripComments  optionVal[bool]
+	minify         optionVal[bool]
+	minifier       optionVal[func([]byte) ([]byte, error)]
+	strictSections optionVal[bool]
+	textMode       optionVal[bool]
+	viewCache      optionVal[int]
+	logger         optionVal[*slog.Logger]
+	renderHook     optionVal[func(string, time.Duration, error)]
+	renderTimeout  optionVal[time.Duration]
+	strictFuncs    optionVal[bool]
+	strictArgs     optionVal[bool]
+	markdown       optionVal[func([]byte) ([]byte, error)]
+	notFoundView   optionVal[string]
+	statusMapper   optionVal[func(error) int]
+
+	duplicatePolicy optionVal[DuplicatePolicy]
+	partialCache    optionVal[partialCacheConfig]
+	bodySection     optionVal[string]
+	headSection     optionVal[string]
+	autoHead        optionVal[bool]
+	componentDir    optionVal[string]
+
+	watch          optionVal[bool]
+	watchInterval  optionVal[time.Duration]
+	sourceComments optionVal[bool]
+	errorSnippets  optionVal[bool]
+	parseWorkers   optionVal[int]
+	maxFileSize    optionVal[int64]
+	bufferPool     optionVal[int]
 }
 
 // Option is a configuration option for a new [Engine].
 // It is passed as argument(s) to [New].
 type Option func(*Config)
 
+// Stats describes the templates of an [Engine], see [Engine.Stats].
+type Stats struct {
+	Layouts  int   // the configured layout and the layouts declared by views
+	Views    int   // views as rendered by [Engine.RenderAll]
+	Partials int   // templates that are not views, including in-memory and Markdown partials
+	Bytes    int64 // total size of the templates, excluding the layouts declared by views
+}
+
+// Overrides maps the paths of partials to the paths of the partials rendered in their place,
+// see [Engine.RenderWithOverrides]. Paths are relative to the root.
+type Overrides map[string]string
+
 // ErrNotFound is returned when a template is not found.
 var ErrNotFound = errors.New("template not found")
 
+// Severity is the severity of a [TemplateError].
+type Severity string
+
+// SeverityError is the severity of errors preventing the templates from being assembled.
+const SeverityError Severity = "error"
+
+// TemplateError is an error at a position in a template file, reported by [New], possibly joined
+// with others, including syntax errors of the template parser. It allows tools, such as editor
+// integrations, to locate the errors with [errors.As].
+//
+// Example:
+//
+//	var terr *mold.TemplateError
+//	if errors.As(err, &terr) {
+//	    fmt.Println(terr.File(), terr.Line(), terr.Column(), terr.Message())
+//	}
+type TemplateError struct {
+	file     string
+	line     int
+	col      int
+	kind     string // type of the template, if reported
+	message  string
+	severity Severity
+	snippet  string // see [WithErrorSnippets]
+}
+
+// File returns the path of the template file.
+func (e *TemplateError) File() string { return e.file }
+
+// Line returns the line of the error, starting at 1.
+func (e *TemplateError) Line() int { return e.line }
+
+// Column returns the column of the error, starting at 1.
+func (e *TemplateError) Column() int { return e.col }
+
+// Message returns the description of the error, without the position.
+func (e *TemplateError) Message() string { return e.message }
+
+// Severity returns the severity of the error.
+func (e *TemplateError) Severity() Severity { return e.severity }
+
+func (e *TemplateError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s:%d:%d: ", e.file, e.line, e.col)
+	if e.kind != "" {
+		b.WriteString(e.kind + ": ")
+	}
+	b.WriteString(e.message)
+	if e.snippet != "" {
+		b.WriteString("\n" + e.snippet)
+	}
+	return b.String()
+}
+
+// DefaultLayoutName is the name of the default layout.
+// It can be passed to [WithLayout] to explicitly select the default layout.
+const DefaultLayoutName = "default_layout"
+
+// DefaultLayout returns the source of the default layout,
+// used when no layout is configured with [WithLayout].
+func DefaultLayout() string {
+	return defaultLayout
+}
+
 // New creates a new [Engine] with fs as the underlying filesystem.
+// fs may be nil if the filesystem is configured with [WithFS].
 //
 // The directory will be traversed and all files matching the configured filename extensions would be parsed.
 // The filename extensions can be configured with [WithExt].
@@ -57,6 +415,8 @@ var ErrNotFound = errors.New("template not found")
 // Layout files are files suffixed (case insensitively) with "layout" before the filename extension.
 // e.g. "layout.html", "Layout.html", "AppLayout.html", "app_layout.html" would all be regarded as layout files
 // and skipped.
+// For compound extensions e.g. ".html.tmpl", the longest configured extension is considered,
+// i.e. "app_layout.html.tmpl" is a layout file only if ".html.tmpl" is configured.
 //
 // Example:
 //
@@ -108,29 +468,675 @@ func With(opts ...Option) Option {
 	}
 }
 
+// WithFS configures the filesystem from which template files are loaded,
+// overriding the filesystem passed to [New].
+// This allows the filesystem to be bundled with other options.
+//
+// Example:
+//
+//	options := mold.With(
+//	    mold.WithFS(os.DirFS("web")),
+//	    mold.WithLayout("layout.html"),
+//	)
+//	engine, err := mold.New(nil, options)
+func WithFS(fsys fs.FS) Option {
+	return func(c *Config) { c.fs = fsys }
+}
+
 // WithRoot configures the base directory from which template files are loaded.
+// The directory must exist and contain at least one template file.
 func WithRoot(subdir string) Option {
 	return func(c *Config) { c.root = newVal(subdir) }
 }
 
+// WithTheme configures the directories of layered themes, from child to parent, in place of the root.
+// Views, partials and layouts are searched in the child theme first, then in its parents,
+// so that a file in a child theme overrides the file at the same path in its parents.
+// The directories are relative to the root and must exist.
+//
+// Example:
+//
+//	option := mold.WithTheme("themes/child", "themes/parent")
+func WithTheme(themes ...string) Option {
+	return func(c *Config) { c.themes = newVal(themes) }
+}
+
 // WithLayout configures the path to the layout file.
+// [DefaultLayoutName] selects the default layout.
 func WithLayout(layout string) Option {
 	return func(c *Config) { c.layout = newVal(layout) }
 }
 
+// WithLayoutString configures the body of the layout, e.g. generated or fetched from a configuration service,
+// as an alternative to a layout file configured with [WithLayout]. It supports sections and partials as layout files,
+// with partial paths resolved against the root.
+//
+// Example:
+//
+//	option := mold.WithLayoutString(`<html><body>{{render}}</body></html>`)
+func WithLayoutString(body string) Option {
+	return func(c *Config) { c.layoutReader = newVal[io.Reader](strings.NewReader(body)) }
+}
+
+// WithLayoutReader is like [WithLayoutString] but reads the body of the layout from r,
+// once by [New]. The size of the body is limited as for files, see [WithMaxFileSize].
+func WithLayoutReader(r io.Reader) Option {
+	return func(c *Config) { c.layoutReader = newVal(r) }
+}
+
 // WithExt configures the filename extensions for the templates.
 // Only files with the specified extensions would be parsed.
+// Compound extensions e.g. ".html.tmpl" are supported, the longest matching extension applies.
+// [New] logs a warning for extensions overlapping with others, e.g. ".tmpl" and ".html.tmpl".
 //
 //	Default: [".html", ".gohtml", ".tpl", ".tmpl"]
 func WithExt(exts ...string) Option {
 	return func(c *Config) { c.exts = newVal(exts) }
 }
 
+// WithAddExt configures additional filename extensions for the templates, appended to
+// the extensions configured with [WithExt], or the default extensions otherwise.
+func WithAddExt(exts ...string) Option {
+	return func(c *Config) {
+		all := slices.Clone(defaultExts)
+		if c.exts.set {
+			all = slices.Clone(c.exts.val)
+		}
+		for _, ext := range exts {
+			if !hasExt(all, ext) {
+				all = append(all, ext)
+			}
+		}
+		c.exts = newVal(all)
+	}
+}
+
+// WithInclude configures glob patterns restricting the template files parsed.
+// If specified, only files with paths matching any of the patterns are parsed as views and partials.
+// The patterns are matched against paths relative to the root, with the semantics of [path.Match].
+//
+// Example:
+//
+//	option := mold.WithInclude("*.html", "views/*.html", "partials/*.html")
+func WithInclude(globs ...string) Option {
+	return func(c *Config) { c.include = newVal(globs) }
+}
+
+// WithExclude configures glob patterns for template files and directories that are not parsed,
+// which are then neither available as views nor as partials.
+// The patterns are matched against paths relative to the root, with the semantics of [path.Match].
+// Exclusions take precedence over inclusions configured with [WithInclude].
+//
+// Example:
+//
+//	option := mold.WithExclude("testdata", "*/fixtures")
+func WithExclude(globs ...string) Option {
+	return func(c *Config) { c.exclude = newVal(globs) }
+}
+
+// WithTranslator configures the translator of messages, called by the "t" template function
+// with the locale of the render, the message key and the arguments, if any.
+// The locale is passed as the "locale" value to [Engine.RenderWith], and is empty otherwise.
+//
+//	option := mold.WithTranslator(func(locale, key string, args ...any) string {
+//	    return catalogs[locale].Sprintf(key, args...)
+//	})
+//
+//	<h1>{{t "welcome.title" .Name}}</h1>
+//	engine.RenderWith(w, "index.html", data, map[string]any{"locale": "de"})
+func WithTranslator(translate func(locale, key string, args ...any) string) Option {
+	return func(c *Config) { c.translator = newVal(translate) }
+}
+
+// WithStandaloneViews configures glob patterns of views rendered without a layout, e.g. HTMX fragments,
+// while other views are rendered with the layout. The body of matching views is executed directly,
+// sections they define are not rendered. A layout may still be chosen with the "layouts" directive
+// or [Engine.RenderWithLayout]. The patterns are matched with the semantics of [WithInclude].
+//
+// Example:
+//
+//	option := mold.WithStandaloneViews("fragments/*.html")
+func WithStandaloneViews(globs ...string) Option {
+	return func(c *Config) { c.standalone = newVal(globs) }
+}
+
+// WithCaseInsensitiveLookup configures whether views are looked up regardless of the case of their path,
+// e.g. "Index.html" renders "index.html". Aliases registered with [WithAlias] are matched the same way.
+// Paths to partials and layouts remain case-sensitive.
+//
+// [New] returns an error if the paths of views or aliases differ only by case, which may happen
+// with case-sensitive filesystems, as it would be ambiguous which one to render.
+//
+//	Default: false
+func WithCaseInsensitiveLookup(insensitive bool) Option {
+	return func(c *Config) { c.caseFold = newVal(insensitive) }
+}
+
+// RenderContext describes a render, passed to the factory configured with [WithFuncMapFactory].
+type RenderContext struct {
+	// View is the path of the view rendered, as requested.
+	View string
+	// Data is the data of the render, before globals are merged, see [WithGlobals].
+	Data any
+	// Values are the per-render values, nil unless rendered with [Engine.RenderWith].
+	Values map[string]any
+	// Request is the request rendered with [Engine.RenderRequest], nil otherwise.
+	Request *http.Request
+	// Context is the context of the request if any, [context.Background] otherwise.
+	Context context.Context
+}
+
+// WithFuncMapFactory configures a factory of template functions depending on the render,
+// e.g. on the request, the current user or flash messages, called on each render of views calling
+// functions depending on the render.
+//
+// The factory is called once by [New] with an empty context, to declare the functions for parsing.
+// It must return functions with the same names and types on every call, which must not conflict with the
+// functions configured with [WithFuncMap]. Templates are neither parsed nor copied again on each render,
+// the declared functions call the functions of the current render instead.
+//
+// Example:
+//
+//	option := mold.WithFuncMapFactory(func(rc mold.RenderContext) template.FuncMap {
+//	    return template.FuncMap{
+//	        "user": func() *User { return userFrom(rc.Context) },
+//	    }
+//	})
+func WithFuncMapFactory(factory func(rc RenderContext) template.FuncMap) Option {
+	return func(c *Config) { c.funcMapFactory = newVal(factory) }
+}
+
+// WithSanitizer configures the sanitizer of untrusted HTML, called by the "sanitize" template function,
+// e.g. for rich content authored by users. Unlike "safe", the output is only trusted once sanitized.
+// The function is available once a sanitizer is configured.
+//
+// Example:
+//
+//	policy := bluemonday.UGCPolicy()
+//	option := mold.WithSanitizer(policy.Sanitize)
+//
+//	<div class="comment">{{sanitize .Comment}}</div>
+func WithSanitizer(sanitize func(html string) string) Option {
+	return func(c *Config) { c.sanitizer = newVal(sanitize) }
+}
+
+// WithIncludeHidden configures whether hidden files and directories, prefixed with a dot, are loaded as templates,
+// e.g. for templates kept in a ".templates" directory.
+//
+// Beware that all hidden directories are then traversed, such as ".git", and files in them with a template
+// filename extension are parsed and may be rendered. Exclude them with [WithExclude], or configure a hidden
+// directory as the root with [WithRoot] instead, which is traversed regardless.
+//
+//	Default: false
+func WithIncludeHidden(hidden bool) Option {
+	return func(c *Config) { c.includeHidden = newVal(hidden) }
+}
+
 // WithFuncMap configures the custom Go template functions.
 func WithFuncMap(funcMap template.FuncMap) Option {
 	return func(c *Config) { c.funcMap = newVal(funcMap) }
 }
 
+// WithPartialFuncMap configures custom Go template functions only available in partials.
+//
+// Templates calling these functions are regarded as partials only,
+// they cannot be rendered as views and are not allowed in layouts.
+// The names must not conflict with functions configured with [WithFuncMap].
+func WithPartialFuncMap(funcMap template.FuncMap) Option {
+	return func(c *Config) { c.partialFuncMap = newVal(funcMap) }
+}
+
+// WithViewFuncMap configures custom Go template functions only available in the view and its partials,
+// e.g. formatting functions specific to a report.
+//
+// It can be specified multiple times to configure multiple views, whose functions may have the same names.
+// The names must not conflict with other functions, e.g. configured with [WithFuncMap].
+// [New] returns an error if the view does not exist, or if the layout or the partials of other views
+// call the functions.
+func WithViewFuncMap(view string, funcMap template.FuncMap) Option {
+	return func(c *Config) {
+		if c.viewFuncMaps == nil {
+			c.viewFuncMaps = map[string]template.FuncMap{}
+		}
+		c.viewFuncMaps[view] = funcMap
+	}
+}
+
+// WithTemplateOption configures options for the underlying templates of layouts, views and partials.
+// The options are forwarded to [template.Template.Option] e.g. "missingkey=error".
+//
+// Example:
+//
+//	option := mold.WithTemplateOption("missingkey=error")
+//	engine, err := mold.New(fs, option)
+func WithTemplateOption(opts ...string) Option {
+	return func(c *Config) { c.templateOptions = newVal(opts) }
+}
+
+// WithBaseTemplate configures an existing template that layouts, views and partials are created from.
+// They inherit its functions, delimiters and options, and the templates it defines are available to all of them.
+// The base template is cloned and never modified, it must not have been executed.
+//
+// Templates defined by the base are plain Go templates, "render" and "partial" are not supported within them.
+// Functions of the base template are unknown to [WithStrictFuncs] and it cannot be used with [WithTextMode].
+//
+// Example:
+//
+//	base := template.Must(template.New("base").Funcs(funcs).ParseFS(fsys, "shared/*.html"))
+//	option := mold.WithBaseTemplate(base)
+func WithBaseTemplate(base *template.Template) Option {
+	return func(c *Config) { c.baseTemplate = newVal(base) }
+}
+
+// WithGlobals configures values that are made available to every render.
+//
+// If the data passed to Render is a map[string]any or nil, the globals are shallowly merged
+// into a copy of it. Values in the data take precedence over globals with the same key.
+//
+// Globals are also accessible with the "globals" function regardless of the type of the data,
+// which is useful when the data is a struct.
+//
+//	{{.CSRFToken}}
+//	{{(globals).CSRFToken}}
+func WithGlobals(globals map[string]any) Option {
+	return func(c *Config) { c.globals = newVal(globals) }
+}
+
+// WithPartial registers an in-memory template body under name, as if it were a file in the filesystem.
+// It can be referenced from views and layouts with the "partial" function.
+//
+// It can be specified multiple times to register multiple partials.
+// A registered partial takes precedence over a file with the same path.
+//
+// Example:
+//
+//	option := mold.WithPartial("partials/banner.html", `<div class="banner">{{.}}</div>`)
+//	engine, err := mold.New(fs, option)
+func WithPartial(name, body string) Option {
+	return func(c *Config) {
+		if c.partials == nil {
+			c.partials = map[string]string{}
+		}
+		c.partials[name] = body
+	}
+}
+
+// WithAlias registers alias as an alternative name for the target view.
+// Rendering the alias renders the assembled target view, without a separate file.
+//
+// It can be specified multiple times to register multiple aliases.
+// [New] returns an error if the target view does not exist or the alias conflicts with a view.
+//
+// Example:
+//
+//	option := mold.WithAlias("/", "index.html")
+//	engine, err := mold.New(fs, option)
+func WithAlias(alias, target string) Option {
+	return func(c *Config) {
+		if c.aliases == nil {
+			c.aliases = map[string]string{}
+		}
+		c.aliases[alias] = target
+	}
+}
+
+// WithBodySection configures the name of the section holding the content of views,
+// rendered in layouts by calling "render" without an argument, or with the name as argument.
+//
+//	Default: "body"
+func WithBodySection(name string) Option {
+	return func(c *Config) { c.bodySection = newVal(name) }
+}
+
+// WithHeadSection configures the name of the section holding the content of the "<head>" tag,
+// rendered by the default layout and left empty for views not defining it, see [WithAutoHead].
+//
+//	Default: "head"
+func WithHeadSection(name string) Option {
+	return func(c *Config) { c.headSection = newVal(name) }
+}
+
+// WithAutoHead configures whether the head section rendered by the layout is left empty for views not defining it.
+// If disabled, [New] returns an error when a view does not define the head section,
+// unless the layout defines a default for it. The name of the section is configured with [WithHeadSection].
+//
+//	Default: true
+func WithAutoHead(auto bool) Option {
+	return func(c *Config) { c.autoHead = newVal(auto) }
+}
+
+// PartialResolver resolves the body of partials missing from the filesystem, see [WithPartialResolver].
+type PartialResolver interface {
+	// Resolve returns the body of the partial at the path relative to the root,
+	// or false if it is not provided.
+	Resolve(name string) (body string, ok bool)
+}
+
+// WithPartialResolver configures a resolver consulted when a partial referenced by a template
+// is not found in the filesystem or registered with [WithPartial], before failing with [ErrNotFound].
+// This enables theme systems, where a child theme provides the filesystem and falls back to the
+// partials of a parent theme. Resolved partials are parsed once, when first referenced.
+//
+// Example:
+//
+//	type parentTheme struct{ fs fs.FS }
+//
+//	func (t parentTheme) Resolve(name string) (string, bool) {
+//	    b, err := fs.ReadFile(t.fs, name)
+//	    return string(b), err == nil
+//	}
+//
+//	option := mold.WithPartialResolver(parentTheme{fs: parentFS})
+func WithPartialResolver(r PartialResolver) Option {
+	return func(c *Config) { c.partialResolver = newVal(r) }
+}
+
+// WithComponentDir configures the directory of components, partials rendered with the "component" function
+// by their path relative to the directory. It is otherwise equivalent to the "partial" function.
+//
+// Example:
+//
+//	option := mold.WithComponentDir("components")
+//
+//	{{component "button.html" .}} renders "components/button.html"
+func WithComponentDir(dir string) Option {
+	return func(c *Config) { c.componentDir = newVal(dir) }
+}
+
+// WithPartialCache enables memoization of partials rendered with the "cachedPartial" function,
+// keeping at most size rendered outputs (0 means unbounded) for the ttl duration (0 means no expiry).
+// Without it, "cachedPartial" renders the partial each time.
+//
+// The output of the partial is cached by its path and the key, which must be comparable,
+// it is up to the caller to ensure the key covers the inputs of the partial.
+// The data passed to the partial defaults to the view's data context.
+//
+//	{{cachedPartial "partials/big_table.html" .Version .Table}}
+//
+// The cache is cleared on [Engine.Reload].
+func WithPartialCache(size int, ttl time.Duration) Option {
+	return func(c *Config) { c.partialCache = newVal(partialCacheConfig{size: size, ttl: ttl}) }
+}
+
+type partialCacheConfig struct {
+	size int
+	ttl  time.Duration
+}
+
+// DuplicatePolicy controls how template paths that differ only in case are handled,
+// e.g. "Foo.html" and "foo.html", as they collide on case-insensitive filesystems.
+type DuplicatePolicy int
+
+// duplicate policies
+const (
+	// DuplicateWarn logs a warning with the logger configured with [WithLogger], keeping both templates.
+	DuplicateWarn DuplicatePolicy = iota
+	// DuplicateError causes [New] to return an error.
+	DuplicateError
+	// DuplicateLastWins keeps the last template in lexical order, silently.
+	DuplicateLastWins
+)
+
+// WithDuplicatePolicy configures how template paths that differ only in case are handled.
+//
+//	Default: DuplicateWarn
+func WithDuplicatePolicy(policy DuplicatePolicy) Option {
+	return func(c *Config) { c.duplicatePolicy = newVal(policy) }
+}
+
+// WithWatch configures whether the filesystem is watched for changes, intended for development.
+// The filesystem is polled every second and all templates, including the layout, are parsed again
+// once a file is modified, added or removed. [Engine.Close] must be called to stop watching.
+//
+// Errors while parsing are logged and returned by renders until fixed, the previous templates are retained.
+// Changes are detected by the modification time and size of the template files, polled rather than
+// notified to avoid dependencies specific to each operating system. Only filesystems returned by
+// [os.DirFS] are watched, including with [WithRoot] or [WithTheme], others such as [embed.FS]
+// do not change and the option has no effect.
+//
+//	Default: false
+func WithWatch(watch bool) Option {
+	return func(c *Config) { c.watch = newVal(watch) }
+}
+
+// WithMaxFileSize configures the maximum size in bytes of template files, as well as files inserted
+// with the "include" function. Larger files are refused with an error, preventing a single huge file
+// from exhausting memory when loading templates from an untrusted directory.
+// A size of 0 means unlimited.
+//
+//	Default: 0
+func WithMaxFileSize(size int64) Option {
+	return func(c *Config) { c.maxFileSize = newVal(size) }
+}
+
+// WithBufferPool configures whether the buffers of buffered renders are recycled, e.g. by [Engine.Handler]
+// and [Engine.RenderBytes], with the initial capacity in bytes of new buffers. Sizing buffers to typical pages
+// avoids reallocations as the output grows, reducing allocations on busy servers.
+// Buffers grown beyond 1 MiB, or the initial capacity if larger, are not recycled.
+//
+//	Default: disabled, a buffer is allocated for each render
+//
+// Example:
+//
+//	option := mold.WithBufferPool(32 << 10)
+func WithBufferPool(initialCap int) Option {
+	return func(c *Config) { c.bufferPool = newVal(initialCap) }
+}
+
+// WithParseWorkers configures the number of template files read and parsed concurrently by [New],
+// which speeds up the creation of an Engine with many templates. Views are assembled sequentially.
+// The filesystem must be safe for concurrent use, as are [os.DirFS] and [embed.FS].
+//
+//	Default: 1
+func WithParseWorkers(workers int) Option {
+	return func(c *Config) { c.parseWorkers = newVal(workers) }
+}
+
+// WithSourceComments configures whether HTML comments mark the boundaries of partials and sections
+// in the rendered output, intended for debugging during development.
+//
+//	<!-- partial: card.html -->...<!-- /partial: card.html -->
+//
+// It cannot be combined with [WithMinify], [WithStripComments] or [WithTextMode].
+//
+//	Default: false
+func WithSourceComments(comments bool) Option {
+	return func(c *Config) { c.sourceComments = newVal(comments) }
+}
+
+// WithErrorSnippets configures whether errors in template files include the offending line,
+// with a caret pointing at the column of the invalid action. Syntax errors reported by the
+// template parser carry the line only, the caret points at the first action on the line.
+//
+//	view.html:3:4: view: path to partial file is not specified
+//		<p>{{partial}}</p>
+//		   ^
+//
+//	Default: false
+func WithErrorSnippets(snippets bool) Option {
+	return func(c *Config) { c.errorSnippets = newVal(snippets) }
+}
+
+// WithNotFoundView configures the view rendered in place of views that do not exist,
+// with the same data. If the fallback view does not exist either, [ErrNotFound] is returned.
+//
+// [Engine.Handler] and [Engine.RenderGzip] respond with the status 404 when the fallback view is rendered.
+//
+// Example:
+//
+//	option := mold.WithNotFoundView("404.html")
+func WithNotFoundView(view string) Option {
+	return func(c *Config) { c.notFoundView = newVal(view) }
+}
+
+// WithStatusMapper configures the HTTP status written by [Engine.Handler] for errors
+// returned by the resolver or the render. Errors mapped to 0 get the default status,
+// 404 for errors wrapping [ErrNotFound] and 500 for any other error.
+//
+// Example:
+//
+//	option := mold.WithStatusMapper(func(err error) int {
+//	    if errors.Is(err, ErrForbidden) {
+//	        return http.StatusForbidden
+//	    }
+//	    return 0
+//	})
+func WithStatusMapper(mapper func(err error) int) Option {
+	return func(c *Config) { c.statusMapper = newVal(mapper) }
+}
+
+// WithStripComments configures whether HTML comments are removed from the rendered output.
+// Comments in template files are already dropped by html/template, this additionally
+// strips comments coming from trusted content e.g. [template.HTML] values.
+//
+// Comments within "<script>", "<style>", "<textarea>" and "<title>" elements are left intact,
+// as are conditional comments e.g. "<!--[if IE]>...<![endif]-->".
+//
+// The output of each render is buffered in memory before it is written.
+//
+//	Default: false
+func WithStripComments(strip bool) Option {
+	return func(c *Config) { c.stripComments = newVal(strip) }
+}
+
+// WithMinify configures whether the rendered HTML output is minified.
+// Runs of whitespace are collapsed into a single space, and removed next to block elements
+// e.g. "<div>" or "<li>" where they are not significant. Closing tags are kept.
+//
+// The content of "<pre>", "<textarea>", "<script>", "<style>" and "<title>" elements is left intact.
+// A different minifier can be configured with [WithMinifier].
+//
+// The output of each render is buffered in memory before it is written.
+//
+//	Default: false
+func WithMinify(minify bool) Option {
+	return func(c *Config) { c.minify = newVal(minify) }
+}
+
+// WithMinifier configures the function minifying the rendered output, enabling [WithMinify].
+//
+// Example:
+//
+//	option := mold.WithMinifier(func(b []byte) ([]byte, error) {
+//	    return m.Bytes("text/html", b)
+//	})
+func WithMinifier(minifier func([]byte) ([]byte, error)) Option {
+	return func(c *Config) {
+		c.minifier = newVal(minifier)
+		c.minify = newVal(true)
+	}
+}
+
+// WithStrictSections configures whether sections defined in views must be rendered,
+// and sections rendered by the layout must be defined.
+// If enabled, [New] returns an error when a view defines a section that is neither
+// rendered by the layout nor referenced within the view or its partials, or when
+// the layout renders a section that remains empty, i.e. not defined by the view and without
+// a default defined in the layout. The sections of the default layout are optional,
+// as is the head section of any layout, unless disabled with [WithAutoHead].
+// This catches typos in section names, which would otherwise be silently ignored.
+//
+//	Default: false
+func WithStrictSections(strict bool) Option {
+	return func(c *Config) { c.strictSections = newVal(strict) }
+}
+
+// WithTextMode configures whether views are executed with text/template instead of html/template.
+// This is useful for output other than HTML e.g. plain text emails or configuration files,
+// where the contextual escaping of html/template is undesirable.
+// Layouts, views, partials and sections are composed in the same way in both modes.
+//
+//	Default: false
+func WithTextMode(text bool) Option {
+	return func(c *Config) { c.textMode = newVal(text) }
+}
+
+// WithViewCache configures the maximum number of assembled views kept in memory.
+// When exceeded, the least recently rendered views are evicted and assembled again
+// on their next render. This trades CPU for memory for very large template sets.
+//
+// All views are still assembled and validated by [New].
+// A size of 0 keeps all views in memory.
+//
+//	Default: 0
+func WithViewCache(size int) Option {
+	return func(c *Config) { c.viewCache = newVal(size) }
+}
+
+// WithLogger configures the logger for diagnostics.
+// Parsed templates, assembled views and renders with their duration are logged at debug level.
+//
+//	Default: no logging
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Config) { c.logger = newVal(logger) }
+}
+
+// WithRenderHook configures a function that is called after each render completes,
+// with the view, the duration of the render and the error if any.
+// This is useful for exporting metrics.
+//
+// Example:
+//
+//	option := mold.WithRenderHook(func(view string, d time.Duration, err error) {
+//	    renderDuration.WithLabelValues(view).Observe(d.Seconds())
+//	})
+func WithRenderHook(hook func(view string, d time.Duration, err error)) Option {
+	return func(c *Config) { c.renderHook = newVal(hook) }
+}
+
+// WithRenderTimeout configures the maximum duration of a render, after which an error wrapping
+// [context.DeadlineExceeded] is returned, e.g. for a slow function or a pathological template.
+// The output is buffered and only written once the render completes in time.
+//
+// Templates cannot be cancelled during execution. An abandoned render stops at its next write,
+// but keeps running meanwhile, e.g. while a function blocks.
+//
+//	Default: 0 (no timeout)
+func WithRenderTimeout(d time.Duration) Option {
+	return func(c *Config) { c.renderTimeout = newVal(d) }
+}
+
+// WithStrictFuncs configures whether function calls in templates are strictly validated.
+// If enabled, [New] returns an error listing every function call that is neither predefined
+// nor configured with [WithFuncMap], as well as every "render" and "partial" call
+// that is not a standalone action e.g. within a pipeline or a condition, where it would render nothing.
+//
+//	Default: false
+func WithStrictFuncs(strict bool) Option {
+	return func(c *Config) { c.strictFuncs = newVal(strict) }
+}
+
+// WithMarkdown configures the renderer for Markdown partials, enabling files with the ".md" extension
+// to be used as partials e.g. {{partial "content/about.md"}}.
+//
+// A Markdown partial is a template executed as any other partial, the output is then converted to HTML
+// with the renderer and inserted as is. Markdown partials cannot reference other partials.
+//
+// Example:
+//
+//	option := mold.WithMarkdown(func(src []byte) ([]byte, error) {
+//	    var buf bytes.Buffer
+//	    err := goldmark.Convert(src, &buf)
+//	    return buf.Bytes(), err
+//	})
+func WithMarkdown(renderer func(src []byte) ([]byte, error)) Option {
+	return func(c *Config) { c.markdown = newVal(renderer) }
+}
+
+// WithStrictPartialArgs configures whether the data of partials is validated against the fields
+// the partials declare with an "expects" directive in a leading comment.
+//
+//	{{/* expects: .Name .Email */}}
+//
+// If enabled, [New] returns an error for every field of the data used by a partial that is not declared,
+// and rendering a partial fails with an error naming the partial if a declared field is missing from the data.
+// Partials without the directive are not validated.
+//
+//	Default: false
+func WithStrictPartialArgs(strict bool) Option {
+	return func(c *Config) { c.strictArgs = newVal(strict) }
+}
+
 // HideFS wraps an [fs.FS] and restricts access to files with the specified extensions,
 // essentially hiding them.
 // This is useful to prevent exposing templates (or sensitive files) when serving
@@ -154,7 +1160,11 @@ func HideFS(fsys fs.FS, exts ...string) fs.FS {
 	}
 }
 
-var _ fs.FS = (*hideFS)(nil)
+var (
+	_ fs.FS        = (*hideFS)(nil)
+	_ fs.ReadDirFS = (*hideFS)(nil)
+	_ fs.StatFS    = (*hideFS)(nil)
+)
 
 type hideFS struct {
 	exts []string
@@ -162,11 +1172,85 @@ type hideFS struct {
 }
 
 // Open implements fs.FS.
+// Hidden files are also omitted from the entries of opened directories.
 func (s *hideFS) Open(name string) (fs.File, error) {
-	ext := filepath.Ext(name)
-	if hasExt(s.exts, ext) {
-		return nil, fs.ErrNotExist
+	if s.hidden(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	f, err := s.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	// regular files are not wrapped, e.g. *os.File implements fs.ReadDirFile,
+	// which would hide other interfaces such as io.Seeker, required by http.FileServerFS.
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		return f, nil
 	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if !info.IsDir() {
+		return f, nil
+	}
+	return &hideDir{ReadDirFile: dir, fs: s}, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (s *hideFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if s.hidden(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries, err := fs.ReadDir(s.FS, name)
+	return s.filter(entries), err
+}
+
+// Stat implements fs.StatFS.
+func (s *hideFS) Stat(name string) (fs.FileInfo, error) {
+	if s.hidden(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return fs.Stat(s.FS, name)
+}
+
+func (s *hideFS) hidden(name string) bool {
+	return matchExt(s.exts, name) != ""
+}
 
-	return s.FS.Open(name)
+// filter removes the hidden entries, in place.
+func (s *hideFS) filter(entries []fs.DirEntry) []fs.DirEntry {
+	return slices.DeleteFunc(entries, func(e fs.DirEntry) bool { return s.hidden(e.Name()) })
+}
+
+// hideDir is a directory of a hideFS, omitting hidden entries.
+type hideDir struct {
+	fs.ReadDirFile
+	fs *hideFS
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *hideDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries, err := d.ReadDirFile.ReadDir(n)
+		return d.fs.filter(entries), err
+	}
+
+	// keep reading until n visible entries are found or the directory is exhausted
+	var entries []fs.DirEntry
+	for len(entries) < n {
+		next, err := d.ReadDirFile.ReadDir(n - len(entries))
+		entries = append(entries, d.fs.filter(next)...)
+		if err != nil {
+			if len(entries) > 0 && err == io.EOF {
+				return entries, nil
+			}
+			return entries, err
+		}
+	}
+	return entries, nil
 }
@@ -2,10 +2,23 @@ package mold
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"html/template"
+	"io"
 	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"testing/fstest"
+	"time"
 )
 
 type testFile struct {
@@ -50,6 +63,17 @@ func TestNew_InvalidSub(t *testing.T) {
 	if _, err := New(testFS, WithRoot("invalid")); err == nil {
 		t.Errorf("New() expected error, got nil")
 	}
+
+	_, err := New(testFS, WithRoot("web/view.html"))
+	if err == nil || !strings.Contains(err.Error(), "root 'web/view.html' is not a directory") {
+		t.Errorf("New() error = %v, want not a directory error", err)
+	}
+
+	testFS = createTestFS(testFile{"web/static/style.css", `body {}`}, testFile{"view.html", `Hello`})
+	_, err = New(testFS, WithRoot("web"))
+	if err == nil || !strings.Contains(err.Error(), "root 'web' contains no template files") {
+		t.Errorf("New() error = %v, want no template files error", err)
+	}
 }
 
 func TestNew_Panic(t *testing.T) {
@@ -132,6 +156,100 @@ func TestNew_Ext(t *testing.T) {
 	}
 }
 
+func TestNew_AddExt(t *testing.T) {
+	testFS := createTestFS(testFile{"view.mine", "Hello {{.Name}}"})
+
+	tests := []struct {
+		options  []Option
+		expected []string
+	}{
+		{options: []Option{WithAddExt(".mine", ".HTML")}, expected: append(slices.Clone(defaultExts), ".mine")},
+		{options: []Option{WithExt(".html"), WithAddExt(".mine")}, expected: []string{".html", ".mine"}},
+	}
+	for _, tt := range tests {
+		var c Config
+		for _, opt := range tt.options {
+			opt(&c)
+		}
+		if !slices.Equal(c.exts.val, tt.expected) {
+			t.Errorf("exts = %v, want %v", c.exts.val, tt.expected)
+		}
+
+		engine := Must(New(testFS, tt.options...))
+		for _, view := range []string{"view.html", "view.mine"} {
+			if err := engine.Render(io.Discard, view, map[string]any{}); err != nil {
+				t.Errorf("Render(%s) error = %v", view, err)
+			}
+		}
+	}
+}
+
+func TestNew_CompoundExt(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"base_layout.html.tmpl", "<main>{{render}}</main>"},
+		testFile{"component.html.tmpl", "Hello {{.}}"},
+		testFile{"other.tmpl", "Other"},
+	)
+
+	engine := Must(New(testFS, WithExt(".html.tmpl"), WithLayout("base_layout.html.tmpl")))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "component.html.tmpl", "John"); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := "<main>Hello John</main>"; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+	if err := engine.Render(io.Discard, "other.tmpl", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Render() expected ErrNotFound, got %v", err)
+	}
+
+	tests := []struct {
+		exts     []string
+		name     string
+		expected string
+	}{
+		{exts: []string{".tmpl", ".html.tmpl"}, name: "a.html.tmpl", expected: ".html.tmpl"},
+		{exts: []string{".tmpl"}, name: "a.HTML.TMPL", expected: ".TMPL"},
+		{exts: []string{"html"}, name: "dir.html/a.tmpl", expected: ""},
+		{exts: []string{".html.tmpl"}, name: "a.tmpl", expected: ""},
+	}
+	for _, tt := range tests {
+		if ext := matchExt(tt.exts, tt.name); ext != tt.expected {
+			t.Errorf("matchExt(%v, %q) = %q, want %q", tt.exts, tt.name, ext, tt.expected)
+		}
+	}
+
+	// the layout suffix is checked before the longest extension
+	if err := validateLayoutFile([]string{".tmpl"}, "base_layout.html.tmpl"); err == nil {
+		t.Errorf("validateLayoutFile() expected error, got nil")
+	}
+}
+
+func TestNew_ExtValidation(t *testing.T) {
+	testFS := createTestFS(testFile{"page.html.tmpl", "Hello"})
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	if _, err := New(testFS, WithExt(".tmpl", ".html.tmpl", "TMPL"), WithLogger(logger)); err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	for _, expected := range []string{
+		`msg="duplicate filename extension" ext=TMPL duplicate=.tmpl`,
+		`msg="overlapping filename extensions, the longest matching one applies" ext=.tmpl overlapping=.html.tmpl`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("log = %q, expected to contain %q", buf.String(), expected)
+		}
+	}
+
+	for _, ext := range []string{"", ".", "views/.html"} {
+		if _, err := New(testFS, WithExt(".html", ext)); err == nil {
+			t.Errorf("New() with extension %q expected error, got nil", ext)
+		}
+	}
+}
+
 func TestNew_FuncMap(t *testing.T) {
 	testFS := createTestFS(
 		testFile{"layout.html", "{{render}}"},
@@ -296,6 +414,99 @@ func TestRender_TemplateRange(t *testing.T) {
 	}
 }
 
+func TestRender_StandaloneViews(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `<html>{{render}}</html>`},
+		testFile{"print_layout.html", `<print>{{render}}</print>`},
+		testFile{"index.html", `index`},
+		testFile{"fragments/row.html", `{{define "head"}}<title>{{end}}<tr>{{.}}</tr>`},
+		testFile{"fragments/page.html", `{{layouts "print_layout.html"}}page`},
+	)
+	engine := Must(New(testFS, WithLayout("layout.html"), WithStandaloneViews("fragments/*.html")))
+
+	for view, expected := range map[string]string{
+		"index.html":          `<html>index</html>`,
+		"fragments/row.html":  `<tr>OK</tr>`,
+		"fragments/page.html": `<print>page</print>`,
+	} {
+		var buf bytes.Buffer
+		if err := engine.Render(&buf, view, "OK"); err != nil {
+			t.Fatalf("Render(%s) error = %v", view, err)
+		}
+		if buf.String() != expected {
+			t.Errorf("Render(%s) got = %q, want %q", view, buf.String(), expected)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := engine.RenderWithLayout(&buf, "layout.html", "fragments/row.html", "OK"); err != nil {
+		t.Fatalf("RenderWithLayout() error = %v", err)
+	}
+	if expected := `<html><tr>OK</tr></html>`; buf.String() != expected {
+		t.Errorf("RenderWithLayout() got = %q, want %q", buf.String(), expected)
+	}
+
+	if _, err := New(testFS, WithStandaloneViews("[")); err == nil {
+		t.Errorf("New() with invalid pattern expected error, got nil")
+	}
+}
+
+func TestRender_CaseInsensitiveLookup(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `{{render}}`},
+		testFile{"pages/Index.html", `index`},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html"), WithCaseInsensitiveLookup(true), WithAlias("/Home", "pages/Index.html")))
+	for _, view := range []string{"pages/Index.html", "pages/index.html", "PAGES/INDEX.HTML", "/home"} {
+		var buf bytes.Buffer
+		if err := engine.Render(&buf, view, nil); err != nil {
+			t.Errorf("Render(%s) error = %v", view, err)
+		} else if buf.String() != "index" {
+			t.Errorf("Render(%s) got = %q, want %q", view, buf.String(), "index")
+		}
+	}
+
+	engine = Must(New(testFS, WithLayout("layout.html")))
+	if err := engine.Render(io.Discard, "pages/index.html", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Render() expected ErrNotFound without case-insensitive lookup, got %v", err)
+	}
+
+	testFS = createTestFS(
+		testFile{"index.html", `index`},
+		testFile{"INDEX.html", `INDEX`},
+	)
+	if _, err := New(testFS, WithCaseInsensitiveLookup(true)); err == nil {
+		t.Errorf("New() expected error for views differing only by case, got nil")
+	}
+}
+
+func TestNew_IncludeHidden(t *testing.T) {
+	testFS := createTestFS(
+		testFile{".templates/index.html", `Index {{partial "_card.html"}}`},
+		testFile{".templates/_card.html", `Card`},
+	)
+
+	if err := Must(New(testFS)).Render(io.Discard, ".templates/index.html", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Render() expected ErrNotFound, got %v", err)
+	}
+
+	engine := Must(New(testFS, WithIncludeHidden(true)))
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, ".templates/index.html", nil); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "Index Card") {
+		t.Errorf("Render() got = %q, expected to contain %q", buf.String(), "Index Card")
+	}
+
+	// a hidden root is traversed regardless
+	engine = Must(New(testFS, WithRoot(".templates")))
+	if err := engine.Render(io.Discard, "index.html", nil); err != nil {
+		t.Errorf("Render() error = %v", err)
+	}
+}
+
 func TestHideFS_Hidden(t *testing.T) {
 	testFS := createTestFS()
 	hideFS := HideFS(testFS)
@@ -313,3 +524,3017 @@ func TestHideFS_Allowed(t *testing.T) {
 		t.Errorf("Open() expected nil, got %v", err)
 	}
 }
+
+func TestHideFS_ReadDir(t *testing.T) {
+	testFS := createTestFS(testFile{"static/style.css", "body {}"}, testFile{"static/secret_layout.html", ""})
+	hideFS := HideFS(testFS)
+
+	entries, err := fs.ReadDir(hideFS, "static")
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "style.css" {
+		t.Errorf("ReadDir() got = %v, expected only style.css", entries)
+	}
+
+	// directories listed via the opened file e.g. by http.FileServerFS
+	f, err := hideFS.Open("static")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+	entries, err = f.(fs.ReadDirFile).ReadDir(1)
+	if err != nil || len(entries) != 1 || entries[0].Name() != "style.css" {
+		t.Errorf("ReadDir() got = %v, %v, expected only style.css", entries, err)
+	}
+
+	if _, err := fs.Stat(hideFS, "static/secret_layout.html"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() expected ErrNotExist, got %v", err)
+	}
+	if _, err := fs.Stat(hideFS, "static/style.css"); err != nil {
+		t.Errorf("Stat() error = %v", err)
+	}
+}
+
+func TestEngine_HideFS(t *testing.T) {
+	testFS := createTestFS(testFile{"view.tmpl", "view"}, testFile{"style.css", "body {}"})
+	engine := Must(New(testFS, WithExt(".tmpl")))
+
+	hideFS := engine.HideFS()
+	if _, err := hideFS.Open("view.tmpl"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open() expected ErrNotExist, got %v", err)
+	}
+	for _, name := range []string{"style.css", "view.html"} {
+		if _, err := hideFS.Open(name); err != nil {
+			t.Errorf("Open() error = %v", err)
+		}
+	}
+}
+
+func TestRender_StripComments(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", "{{render}}"},
+		testFile{"view.html", "<p>{{.Content}}</p><script>{{.Script}}</script>"},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html"), WithStripComments(true)))
+
+	data := map[string]any{
+		"Content": template.HTML("Hello<!-- comment -->"),
+		"Script":  template.JS("'<!-- script -->'"),
+	}
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "view.html", data); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	expected := "<p>Hello</p><script>'<!-- script -->'</script>"
+	if buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestNew_Partial(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", "{{render}}"},
+		testFile{"view.html", `Hello, {{partial "memory/name.html" .Name}}`},
+	)
+
+	option := With(
+		WithLayout("layout.html"),
+		WithPartial("memory/name.html", "<b>{{.}}</b>"),
+	)
+	engine := Must(New(testFS, option))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "view.html", map[string]any{"Name": "John Doe"}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	expected := "Hello, <b>John Doe</b>"
+	if buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestNew_PartialInvalidRender(t *testing.T) {
+	testFS := createTestFS(testFile{"view.html", `{{partial "memory/name.html"}}`})
+
+	if _, err := New(testFS, WithPartial("memory/name.html", "{{render}}")); err == nil {
+		t.Errorf("New() expected error, got nil")
+	}
+}
+
+func TestDebugTree(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `<main>{{render}}</main>{{render "footer"}}`},
+		testFile{"view.html", `{{define "footer"}}bye{{end}}{{partial "partial.html" .Name}}`},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	tree, err := engine.DebugTree("view.html")
+	if err != nil {
+		t.Fatalf("DebugTree() error = %v", err)
+	}
+
+	expected := `{{define "layout"}}<main>{{template "body" .}}</main>{{template "footer" .}}{{end}}
+{{define "body"}}{{template "partial.html" .Name}}{{end}}
+{{define "footer"}}bye{{end}}
+{{define "partial.html"}}Location: {{.}}{{end}}
+`
+	if tree != expected {
+		t.Errorf("DebugTree() got = %q, want %q", tree, expected)
+	}
+
+	// the output is unchanged by renders, concurrent or not
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = engine.Render(io.Discard, "view.html", map[string]any{"Name": "John"})
+	}()
+	tree, _ = engine.DebugTree("view.html")
+	wg.Wait()
+	if tree != expected {
+		t.Errorf("DebugTree() during render got = %q, want %q", tree, expected)
+	}
+	if tree, _ = engine.DebugTree("view.html"); tree != expected {
+		t.Errorf("DebugTree() after render got = %q, want %q", tree, expected)
+	}
+}
+
+func TestDebugTree_ViewNotFound(t *testing.T) {
+	engine := Must(New(createTestFS()))
+
+	if _, err := engine.DebugTree("nonexistent.html"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DebugTree() expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRender_Globals(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", "{{.Site}}: {{render}}"},
+		testFile{"view.html", "{{.Name}}"},
+		testFile{"struct.html", "{{(globals).Name}} {{.Name}}"},
+	)
+
+	option := With(
+		WithLayout("layout.html"),
+		WithGlobals(map[string]any{"Site": "Mold", "Name": "Global"}),
+	)
+	engine := Must(New(testFS, option))
+
+	tests := []struct {
+		view     string
+		data     any
+		expected string
+	}{
+		{view: "view.html", data: nil, expected: "Mold: Global"},
+		{view: "view.html", data: map[string]any{"Name": "John Doe"}, expected: "Mold: John Doe"},
+		{view: "struct.html", data: struct{ Site, Name string }{"Local", "John Doe"}, expected: "Local: Global John Doe"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := engine.Render(&buf, tt.view, tt.data); err != nil {
+			t.Fatalf("Render() error = %v", err)
+		}
+		if buf.String() != tt.expected {
+			t.Errorf("Render() got = %q, want %q", buf.String(), tt.expected)
+		}
+	}
+}
+
+func TestNew_StrictSections(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `{{render "sidebar"}}{{render}}`},
+		testFile{"view.html", `{{define "sidebar"}}menu{{end}}{{define "local"}}local{{end}}{{template "local"}}{{partial "partial.html"}}{{partial "partial2.html"}}`},
+	)
+
+	if _, err := New(testFS, WithLayout("layout.html"), WithStrictSections(true)); err != nil {
+		t.Errorf("New() expected nil, got %v", err)
+	}
+}
+
+func TestNew_StrictSectionsEmpty(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `{{render "sidebar"}}{{render "footer"}}{{define "footer"}}default{{end}}{{render}}`},
+		testFile{"view.html", `{{partial "partial.html"}}{{partial "partial2.html"}}`},
+		testFile{"other.html", `{{define "sidebar"}}{{end}}`},
+	)
+
+	_, err := New(testFS, WithLayout("layout.html"), WithStrictSections(true))
+	if err == nil || !strings.Contains(err.Error(), "error parsing view 'view.html': sections not defined: sidebar") {
+		t.Errorf("New() expected empty section error, got %v", err)
+	}
+	// sections with a default, defined by other views and partials are not reported
+	if strings.Contains(err.Error(), "footer") || strings.Contains(err.Error(), "other.html") || strings.Contains(err.Error(), "partial") {
+		t.Errorf("New() error = %v, expected only view.html to be reported", err)
+	}
+	if _, err := New(createTestFS(), WithStrictSections(true)); err != nil {
+		t.Errorf("New() expected sections of the default layout to be optional, got %v", err)
+	}
+}
+
+func TestNew_StrictSectionsAutoHead(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `<head>{{render "head"}}</head>{{render "sidebar"}}{{render}}`},
+		testFile{"view.html", `{{define "sidebar"}}menu{{end}}{{partial "partial.html"}}{{partial "partial2.html"}}`},
+	)
+
+	// the head section is left empty by auto head
+	if _, err := New(testFS, WithLayout("layout.html"), WithStrictSections(true)); err != nil {
+		t.Errorf("New() expected nil, got %v", err)
+	}
+
+	_, err := New(testFS, WithLayout("layout.html"), WithStrictSections(true), WithAutoHead(false))
+	if err == nil || !strings.Contains(err.Error(), "head section 'head' not defined") {
+		t.Errorf("New() expected head section error, got %v", err)
+	}
+}
+
+func TestNew_StrictSectionsUnused(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `{{render "sidebar"}}{{render}}`},
+		testFile{"view.html", `{{define "sidebars"}}menu{{end}}`},
+	)
+
+	if _, err := New(testFS, WithLayout("layout.html")); err != nil {
+		t.Fatalf("New() expected nil, got %v", err)
+	}
+
+	_, err := New(testFS, WithLayout("layout.html"), WithStrictSections(true))
+	if err == nil || !strings.Contains(err.Error(), "view.html") || !strings.Contains(err.Error(), "sidebars") {
+		t.Errorf("New() expected unused section error, got %v", err)
+	}
+}
+
+func TestRender_TemplateOption(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", "{{render}}"},
+		testFile{"view.html", `Hello {{.Name}}{{partial "partial.html" .}}`},
+		testFile{"partial.html", `{{.Missing}}`},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html"), WithTemplateOption("missingkey=error")))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "view.html", map[string]any{"Name": "John Doe"}); err == nil {
+		t.Errorf("Render() expected error, got nil")
+	}
+}
+
+func TestNew_InvalidTemplateOption(t *testing.T) {
+	if _, err := New(createTestFS(), WithTemplateOption("invalid")); err == nil {
+		t.Errorf("New() expected error, got nil")
+	}
+}
+
+func TestReload(t *testing.T) {
+	testFS := createTestFS(testFile{"layout.html", "{{render}}"}).(fstest.MapFS)
+
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	testFS["view.html"] = &fstest.MapFile{Data: []byte(`Bye, {{.Name}}!<br>{{partial "partial.html" .Location}}`)}
+	testFS["partial.html"] = &fstest.MapFile{Data: []byte(`Planet: {{.}}`)}
+	if err := engine.Reload("view.html"); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "view.html", map[string]any{"Name": "John Doe", "Location": "Mars"}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	expected := "Bye, John Doe!<br>Planet: Mars"
+	if buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestReload_Error(t *testing.T) {
+	testFS := createTestFS(testFile{"layout.html", "{{render}}"}).(fstest.MapFS)
+
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	testFS["view.html"] = &fstest.MapFile{Data: []byte(`{{partial "invalid.html"}}`)}
+	if err := engine.Reload("view.html"); err == nil {
+		t.Errorf("Reload() expected error, got nil")
+	}
+	if err := engine.Reload("layout.html"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Reload() expected ErrNotFound, got %v", err)
+	}
+
+	// the previous view remains in use
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "view.html", map[string]any{"Name": "John Doe", "Location": "Mars"}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	expected := "Hello, John Doe!<br>Location: Mars"
+	if buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestReload_Options(t *testing.T) {
+	// functions are checked as by New
+	testFS := createTestFS(testFile{"layout.html", "{{render}}"}).(fstest.MapFS)
+	engine := Must(New(testFS, WithLayout("layout.html"), WithStrictFuncs(true)))
+	testFS["view.html"] = &fstest.MapFile{Data: []byte(`{{if partial "partial.html"}}{{end}}`)}
+	if err := engine.Reload("view.html"); err == nil || !strings.Contains(err.Error(), "standalone action") {
+		t.Errorf("Reload() error = %v, expected partial not used as a standalone action", err)
+	}
+
+	// views are looked up regardless of case, including views added by the reload
+	testFS = createTestFS(testFile{"layout.html", "{{render}}"}).(fstest.MapFS)
+	engine = Must(New(testFS, WithLayout("layout.html"), WithCaseInsensitiveLookup(true)))
+	testFS["About.html"] = &fstest.MapFile{Data: []byte(`About`)}
+	if err := engine.Reload("About.html"); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "about.HTML", nil); err != nil || buf.String() != "About" {
+		t.Errorf("Render() = %q, %v, want %q", buf.String(), err, "About")
+	}
+}
+
+// TestReload_Concurrent is meant to be run with -race, reloads replace the cached views being rendered.
+func TestReload_Concurrent(t *testing.T) {
+	engine := Must(New(createTestFS(), WithLayout("layout.html")))
+	data := map[string]any{"Name": "John Doe", "Location": "Mars"}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for range 200 {
+			if err := engine.Reload("view.html"); err != nil {
+				t.Errorf("Reload() error = %v", err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range 200 {
+			if err := engine.Render(io.Discard, "view.html", data); err != nil {
+				t.Errorf("Render() error = %v", err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+func TestRender_Concurrent(t *testing.T) {
+	testFS := createTestFS(testFile{"view2.html", `{{partial "partial.html" .Location}}`})
+
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	data := map[string]any{
+		"Name":     "John Doe",
+		"Location": "Mars",
+		"Age":      40,
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := engine.Render(io.Discard, "view.html", data); err != nil {
+				t.Errorf("Render() error = %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := engine.Render(io.Discard, "view2.html", data); err != nil {
+				t.Errorf("Render() error = %v", err)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := engine.Reload("view.html"); err != nil {
+			t.Errorf("Reload() error = %v", err)
+		}
+	}()
+	wg.Wait()
+}
+
+func TestRender_Include(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", "{{render}}"},
+		testFile{"view.html", `<i>{{include "icons/logo.svg"}}</i>`},
+		testFile{"icons/logo.svg", `<svg>{{.Name}}</svg>`},
+		testFile{"traversal.html", `{{include "../icons/logo.svg"}}`},
+		testFile{"missing.html", `{{include "icons/missing.svg"}}`},
+		testFile{"data.html", `{{include .}}`},
+		testFile{".env", `SECRET=1`},
+		testFile{".git/config", `[core]`},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "view.html", nil); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	expected := "<i><svg>{{.Name}}</svg></i>"
+	if buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	for _, view := range []string{"traversal.html", "missing.html"} {
+		if err := engine.Render(io.Discard, view, nil); err == nil {
+			t.Errorf("Render() expected error for %s, got nil", view)
+		}
+	}
+
+	// hidden files are not included, e.g. with a path provided by the data
+	for _, name := range []string{".env", ".git/config", "./.env"} {
+		buf.Reset()
+		if err := engine.Render(&buf, "data.html", name); err == nil {
+			t.Errorf("Render() of hidden file %s got = %q, expected error", name, buf.String())
+		}
+	}
+}
+
+func TestRender_TextMode(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.txt", `{{render "subject"}}{{render}}{{partial "signature.txt"}}`},
+		testFile{"email.txt", `{{define "subject"}}Subject: {{.Subject}}{{"\n"}}{{end}}Hello, {{.Name}}!`},
+		testFile{"signature.txt", "\n-- <Mold & Co>"},
+	)
+
+	option := With(
+		WithExt(".txt"),
+		WithLayout("layout.txt"),
+		WithTextMode(true),
+	)
+	engine := Must(New(testFS, option))
+
+	data := map[string]any{
+		"Subject": "Tom & Jerry",
+		"Name":    "<John Doe>",
+	}
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "email.txt", data); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	expected := "Subject: Tom & Jerry\nHello, <John Doe>!\n-- <Mold & Co>"
+	if buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestNew_MultipleErrors(t *testing.T) {
+	tests := []struct {
+		files    []testFile
+		expected []string
+	}{
+		{
+			files:    []testFile{{"a.html", "{{if}}"}, {"b.html", "{{end}}"}},
+			expected: []string{"a.html:1", "b.html:1"},
+		},
+		{
+			files:    []testFile{{"c.html", "\n{{partial}}"}, {"d.html", `{{partial "missing.html"}}`}},
+			expected: []string{"c.html:2:1", "missing.html"},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			_, err := New(createTestFS(tt.files...))
+			if err == nil {
+				t.Fatalf("New() expected error, got nil")
+			}
+
+			for _, expected := range tt.expected {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("New() error = %q, expected to contain %q", err, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestRender_ViewCache(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"view2.html", `Bye, {{.Name}}!<br>{{partial "partial.html" .Location}}`},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html"), WithViewCache(1)))
+
+	data := map[string]any{
+		"Name":     "John Doe",
+		"Location": "Mars",
+		"Age":      40,
+	}
+
+	expected := map[string]string{
+		"view.html":  "<html><body>Hello, John Doe!<br>Location: Mars<br>Age: 40</body></html>",
+		"view2.html": "<html><body>Bye, John Doe!<br>Location: Mars<br>Age: 40</body></html>",
+	}
+	for i := 0; i < 2; i++ {
+		for _, view := range []string{"view.html", "view2.html"} {
+			var buf bytes.Buffer
+			if err := engine.Render(&buf, view, data); err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if buf.String() != expected[view] {
+				t.Errorf("Render() got = %q, want %q", buf.String(), expected[view])
+			}
+		}
+	}
+
+	if n := engine.(*moldEngine).views.len(); n != 1 {
+		t.Errorf("cached views = %d, expected 1", n)
+	}
+}
+
+func TestRender_SectionData(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `{{render "breadcrumb" .Nav}}|{{render}}`},
+		testFile{"view.html", `{{define "breadcrumb"}}{{range .}}/{{.}}{{end}}{{end}}{{.Name}}`},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	data := map[string]any{
+		"Name": "John Doe",
+		"Nav":  []string{"home", "users"},
+	}
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "view.html", data); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	expected := "/home/users|John Doe"
+	if buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestRender_RelativePartial(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layouts/layout.html", `{{partial "./nav.html"}}|{{render}}`},
+		testFile{"layouts/nav.html", `nav`},
+		testFile{"admin/users/index.html", `{{partial "_row.html"}},{{partial "./_row.html"}},{{partial "../_footer.html"}},{{partial "partial.html" .}}`},
+		testFile{"admin/users/_row.html", `row`},
+		testFile{"admin/_footer.html", `footer`},
+		testFile{"_row.html", `root row`},
+	)
+
+	engine := Must(New(testFS, WithLayout("layouts/layout.html")))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "admin/users/index.html", "Mars"); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	expected := "nav|row,row,footer,Location: Mars"
+	if buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestRender_RelativePartialNotFound(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"admin/index.html", `{{partial "./partial.html"}}`},
+	)
+
+	if _, err := New(testFS); err == nil {
+		t.Errorf("New() expected error, got nil")
+	}
+}
+
+func TestNew_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	engine := Must(New(createTestFS(), WithLayout("layout.html"), WithLogger(logger)))
+	if err := engine.Render(io.Discard, "view.html", nil); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	for _, expected := range []string{
+		`msg="parsed template" path=view.html`,
+		`msg="assembled view" view=view.html partials=[partial.html]`,
+		`msg="rendered view" view=view.html duration=`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("log = %q, expected to contain %q", buf.String(), expected)
+		}
+	}
+}
+
+func TestRender_Hook(t *testing.T) {
+	var views []string
+	var errs []error
+	hook := func(view string, d time.Duration, err error) {
+		views = append(views, view)
+		errs = append(errs, err)
+	}
+
+	engine := Must(New(createTestFS(), WithRenderHook(hook)))
+
+	_ = engine.Render(io.Discard, "view.html", nil)
+	_ = engine.Render(io.Discard, "missing.html", nil)
+
+	if len(views) != 2 || views[0] != "view.html" || views[1] != "missing.html" {
+		t.Errorf("hook views = %v, expected [view.html missing.html]", views)
+	}
+	if len(errs) != 2 || errs[0] != nil || !errors.Is(errs[1], ErrNotFound) {
+		t.Errorf("hook errors = %v, expected [nil ErrNotFound]", errs)
+	}
+}
+
+func TestNew_StrictFuncs(t *testing.T) {
+	tests := []struct {
+		file     testFile
+		expected string
+	}{
+		{
+			file:     testFile{"index.html", `{{if partial "partial.html"}}{{end}}`},
+			expected: "index.html:1:6: partial must be used as a standalone action",
+		},
+		{
+			file:     testFile{"index.html", "\n{{$x := render}}"},
+			expected: "index.html:2:9: render must be used as a standalone action",
+		},
+		{
+			file:     testFile{"index.html", `{{printf "%s" (partial "partial.html")}}`},
+			expected: "index.html:1:16: partial must be used as a standalone action",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			testFS := createTestFS(tt.file)
+
+			_, err := New(testFS, WithStrictFuncs(true))
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("New() error = %v, expected to contain %q", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNew_StrictFuncsValid(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"index.html", `{{partial "partial.html" .}}{{with .Name}}{{upper . | printf "%s"}}{{end}}{{include "partial.html"}}`},
+	)
+
+	funcMap := map[string]any{"upper": strings.ToUpper}
+	if _, err := New(testFS, WithStrictFuncs(true), WithFuncMap(funcMap)); err != nil {
+		t.Errorf("New() expected nil, got %v", err)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"view.html", `{{define "title"}}Hi {{.Name}}{{end}}Hello, {{.Name}}!`},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html")))
+	data := map[string]any{"Name": "John Doe", "Age": 40}
+
+	// the template is available after the view is rendered
+	if err := engine.Render(io.Discard, "view.html", data); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	tpl, err := engine.Template("view.html")
+	if err != nil {
+		t.Fatalf("Template() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.ExecuteTemplate(&buf, "title", data); err != nil {
+		t.Fatalf("ExecuteTemplate() error = %v", err)
+	}
+	if expected := "Hi John Doe"; buf.String() != expected {
+		t.Errorf("ExecuteTemplate() got = %q, want %q", buf.String(), expected)
+	}
+
+	// modifications do not affect the engine
+	tpl, _ = engine.Template("view.html")
+	template.Must(tpl.New("body").Parse("modified"))
+	buf.Reset()
+	if err := engine.Render(&buf, "view.html", data); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := "<html><body>Hello, John Doe!<br>Age: 40</body></html>"; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	if _, err := engine.Template("missing.html"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Template() expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestNew_FS(t *testing.T) {
+	testFS := createTestFS(testFile{"web/index.html", `Hello`})
+
+	engine := Must(New(nil, With(WithFS(testFS), WithRoot("web"))))
+	if err := engine.Render(io.Discard, "index.html", nil); err != nil {
+		t.Errorf("Render() error = %v", err)
+	}
+
+	if _, err := New(nil); err == nil {
+		t.Errorf("New() expected error, got nil")
+	}
+}
+
+func TestRender_NestedPartial(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `{{render}}|{{partial "partials/nav.html"}}`},
+		testFile{"view.html", `{{partial "partials/card.html" .}}`},
+		testFile{"partials/card.html", `<div>{{partial "./title.html" .Name}}</div>`},
+		testFile{"partials/title.html", `<h1>{{.}}</h1>`},
+		testFile{"partials/nav.html", `<nav>{{partial "partials/title.html" .Name}}</nav>`},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "view.html", map[string]any{"Name": "John Doe"}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	expected := "<div><h1>John Doe</h1></div>|<nav><h1>John Doe</h1></nav>"
+	if buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestNew_IndirectCyclicReferenceError(t *testing.T) {
+	tests := []struct {
+		files    []testFile
+		expected string
+	}{
+		{
+			files: []testFile{
+				{"a.html", `{{partial "b.html"}}`},
+				{"b.html", `{{partial "a.html"}}`},
+			},
+			expected: "cyclic reference: a.html -> b.html -> a.html",
+		},
+		{
+			files: []testFile{
+				{"a.html", `{{partial "b.html"}}`},
+				{"b.html", `{{partial "c.html"}}`},
+				{"c.html", `{{partial "a.html"}}`},
+			},
+			expected: "cyclic reference: a.html -> b.html -> c.html -> a.html",
+		},
+		{
+			files: []testFile{
+				{"layout.html", `{{render}}{{partial "b.html"}}`},
+				{"b.html", `{{partial "c.html"}}`},
+				{"c.html", `{{partial "b.html"}}`},
+			},
+			expected: "cyclic reference: b.html -> c.html -> b.html",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			_, err := New(createTestFS(tt.files...), WithLayout("layout.html"))
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("New() error = %v, expected to contain %q", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNew_SectionCyclicReferenceError(t *testing.T) {
+	tests := []struct {
+		files    []testFile
+		expected string
+	}{
+		{
+			files: []testFile{
+				{"layout.html", `{{render "head"}}{{render}}`},
+				{"view.html", `{{define "head"}}{{template "body"}}{{end}}<p>{{template "head"}}</p>`},
+			},
+			expected: "cyclic reference: head -> body -> head",
+		},
+		{
+			files: []testFile{
+				{"layout.html", `{{render "head"}}{{render}}`},
+				{"view.html", `{{define "head"}}<title>{{template "head"}}</title>{{end}}`},
+			},
+			expected: "cyclic reference: head -> head",
+		},
+		{
+			files: []testFile{
+				{"layout.html", `{{render "head"}}{{render}}`},
+				{"view.html", `{{define "head"}}{{partial "p.html"}}{{end}}`},
+				{"p.html", `{{template "head"}}`},
+			},
+			expected: "cyclic reference: head -> p.html -> head",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			_, err := New(createTestFS(tt.files...), WithLayout("layout.html"))
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("New() error = %v, expected to contain %q", err, tt.expected)
+			}
+		})
+	}
+
+	// recursion guarded by a conditional action is bounded by the data
+	testFS := createTestFS(
+		testFile{"layout.html", `{{render}}`},
+		testFile{"view.html", `{{define "tree"}}{{range .}}<li>{{.Name}}<ul>{{template "tree" .Children}}</ul></li>{{end}}{{end}}{{template "tree" .}}`},
+	)
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	type node struct {
+		Name     string
+		Children []node
+	}
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "view.html", []node{{Name: "a", Children: []node{{Name: "b"}}}}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := "<li>a<ul><li>b<ul></ul></li></ul></li>"; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testFS := createTestFS().(fstest.MapFS)
+
+	engine := Must(New(testFS, WithLayout("layout.html")))
+	if err := engine.Validate(); err != nil {
+		t.Errorf("Validate() expected nil, got %v", err)
+	}
+
+	testFS["view.html"] = &fstest.MapFile{Data: []byte(`{{partial "missing.html"}}`)}
+	testFS["broken.html"] = &fstest.MapFile{Data: []byte(`{{partial "partial.html"}}{{partial "other.html"}}`)}
+	err := engine.Validate()
+	if err == nil {
+		t.Fatalf("Validate() expected error, got nil")
+	}
+	for _, expected := range []string{"missing.html", "other.html"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Validate() error = %q, expected to contain %q", err, expected)
+		}
+	}
+
+	// the engine is not modified
+	if err := engine.Render(io.Discard, "view.html", nil); err != nil {
+		t.Errorf("Render() error = %v", err)
+	}
+}
+
+func TestNew_DefaultLayout(t *testing.T) {
+	engine := Must(New(createTestFS(), WithLayout(DefaultLayoutName)))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "partial.html", "Mars"); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	if !strings.Contains(DefaultLayout(), "{{ render }}") {
+		t.Errorf("DefaultLayout() = %q, expected to render the body", DefaultLayout())
+	}
+	expected := strings.ReplaceAll(DefaultLayout(), "{{ render \"head\" }}", "")
+	expected = strings.ReplaceAll(expected, "{{ render }}", "Location: Mars")
+	if buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestNew_PartialFuncMap(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", "{{render}}"},
+		testFile{"view.html", `{{partial "card.html" .Name}}`},
+		testFile{"card.html", `<b>{{shout .}}</b>`},
+	)
+
+	funcMap := map[string]any{"shout": strings.ToUpper}
+	engine := Must(New(testFS, WithLayout("layout.html"), WithPartialFuncMap(funcMap)))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "view.html", map[string]any{"Name": "John Doe"}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := "<b>JOHN DOE</b>"; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	// partials calling partial functions cannot be rendered as views
+	if err := engine.Render(io.Discard, "card.html", "John Doe"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Render() expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestNew_PartialFuncMapInvalid(t *testing.T) {
+	funcMap := map[string]any{"shout": strings.ToUpper}
+
+	testFS := createTestFS(testFile{"layout.html", "{{shout render}}"})
+	if _, err := New(testFS, WithLayout("layout.html"), WithPartialFuncMap(funcMap)); err == nil {
+		t.Errorf("New() expected error, got nil")
+	}
+
+	if _, err := New(createTestFS(), WithFuncMap(funcMap), WithPartialFuncMap(funcMap)); err == nil {
+		t.Errorf("New() expected error, got nil")
+	}
+}
+
+func TestRender_Safe(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", "{{render}}"},
+		testFile{"view.html", `{{safe .HTML}}<a href="{{safeURL .URL}}" style="{{safeCSS .CSS}}" onclick="{{safeJS .JS}}">{{.HTML}}</a>`},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	data := map[string]any{
+		"HTML": "<b>bold</b>",
+		"URL":  "javascript:void(0)",
+		"CSS":  "color: expression(red)",
+		"JS":   "go('home')",
+	}
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "view.html", data); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	expected := `<b>bold</b><a href="javascript:void%280%29" style="color: expression(red)" onclick="go(&#39;home&#39;)">&lt;b&gt;bold&lt;/b&gt;</a>`
+	if buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestRender_Markdown(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", "{{render}}"},
+		testFile{"view.html", `<article>{{partial "content/about.md" .User}}</article>`},
+		testFile{"content/about.md", "# About\n{{.}}"},
+	)
+
+	// a trivial renderer converting headings only
+	renderer := func(src []byte) ([]byte, error) {
+		heading, text, _ := strings.Cut(string(src), "\n")
+		return []byte("<h1>" + strings.TrimPrefix(heading, "# ") + "</h1><p>" + text + "</p>"), nil
+	}
+	engine := Must(New(testFS, WithLayout("layout.html"), WithMarkdown(renderer)))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "view.html", map[string]any{"User": "<John>"}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := "<article><h1>About</h1><p>&lt;John&gt;</p></article>"; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	if err := engine.Render(io.Discard, "content/about.md", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Render() expected ErrNotFound, got %v", err)
+	}
+
+	// validating the changed partial does not modify the engine
+	testFS.(fstest.MapFS)["content/about.md"] = &fstest.MapFile{Data: []byte("# Changed\n{{.}}")}
+	if err := engine.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	buf.Reset()
+	if err := engine.Render(&buf, "view.html", map[string]any{"User": "John"}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := "<article><h1>About</h1><p>John</p></article>"; buf.String() != expected {
+		t.Errorf("Render() after Validate() got = %q, want %q", buf.String(), expected)
+	}
+
+	// Markdown files are not recognized without a renderer
+	if _, err := New(testFS, WithLayout("layout.html")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("New() expected ErrNotFound, got %v", err)
+	}
+
+	// Markdown partials cannot reference other partials
+	testFS = createTestFS(
+		testFile{"view.html", `{{partial "about.md"}}`},
+		testFile{"about.md", `{{partial "view.html"}}`},
+	)
+	if _, err := New(testFS, WithMarkdown(renderer)); err == nil {
+		t.Errorf("New() expected error, got nil")
+	}
+}
+
+func TestNew_PartialInvalidRenderPosition(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"view.html", `{{partial "partials/foo.html"}}`},
+		testFile{"partials/foo.html", "<div>\n  <p>{{.}}</p>\n {{render \"head\"}}\n</div>"},
+	)
+
+	_, err := New(testFS)
+	if err == nil {
+		t.Fatalf("New() expected error, got nil")
+	}
+	if expected := "partials/foo.html:3:2: partial: render not supported"; !strings.Contains(err.Error(), expected) {
+		t.Errorf("New() error = %q, expected to contain %q", err, expected)
+	}
+}
+
+func TestNew_IncludeExclude(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"view.html", `{{partial "partials/card.html"}}`},
+		testFile{"partials/card.html", `card`},
+		testFile{"testdata/broken.html", `{{`},
+		testFile{"fixtures/broken.html", `{{`},
+		testFile{"excluded.html", `{{partial "testdata/broken.html"}}`},
+	)
+
+	if _, err := New(testFS); err == nil {
+		t.Fatalf("New() expected error, got nil")
+	}
+
+	engine, err := New(testFS, WithExclude("testdata", "fixtures/*", "excluded.html"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := engine.Render(io.Discard, "view.html", nil); err != nil {
+		t.Errorf("Render() error = %v", err)
+	}
+	if err := engine.Render(io.Discard, "excluded.html", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Render() expected ErrNotFound, got %v", err)
+	}
+
+	engine, err = New(testFS, WithInclude("*.html", "partials/*"), WithExclude("excluded.html"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := engine.Render(io.Discard, "view.html", nil); err != nil {
+		t.Errorf("Render() error = %v", err)
+	}
+
+	// excluded files are not available as partials
+	if _, err := New(testFS, WithExclude("partials/*", "testdata", "fixtures", "excluded.html")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("New() expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := New(testFS, WithExclude("[")); err == nil {
+		t.Errorf("New() expected error for invalid pattern, got nil")
+	}
+}
+
+func TestRender_Alias(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", "{{render}}"},
+		testFile{"index.html", `Hello {{.Name}}`},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html"), WithAlias("/", "index.html")))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "/", map[string]any{"Name": "John"}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := "Hello John"; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	if _, err := New(testFS, WithAlias("/", "missing.html")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("New() expected ErrNotFound, got %v", err)
+	}
+	if _, err := New(testFS, WithAlias("index.html", "layout.html")); err == nil {
+		t.Errorf("New() expected error, got nil")
+	}
+}
+
+func TestRender_LayoutStack(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"outer_layout.html", `<html>{{render "head"}}|{{render}}</html>`},
+		testFile{"card_layout.html", `<div>{{render "title"}}{{render}}{{partial "footer.html"}}</div>`},
+		testFile{"footer.html", `<footer></footer>`},
+		testFile{"view.html", `{{layouts "outer_layout.html" "card_layout.html"}}{{define "head"}}<title>Head</title>{{end}}{{define "title"}}<h2>Title</h2>{{end}}<p>{{.}}</p>`},
+		testFile{"card.html", `{{layouts "card_layout.html"}}<p>{{.}}</p>`},
+		testFile{"card2.html", `{{layouts "card_layout.html"}}<p>{{.}}</p>`},
+	)
+
+	engine := Must(New(testFS))
+
+	// each stack of layouts is parsed once, views sharing it clone it
+	if stacks := engine.(*moldEngine).stacks; len(stacks) != 2 {
+		t.Errorf("stacked layouts = %d, expected 2", len(stacks))
+	}
+
+	tests := []struct {
+		view     string
+		expected string
+	}{
+		{"view.html", `<html><title>Head</title>|<div><h2>Title</h2><p>content</p><footer></footer></div></html>`},
+		{"card.html", `<div><p>content</p><footer></footer></div>`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := engine.Render(&buf, tt.view, "content"); err != nil {
+			t.Fatalf("Render() error = %v", err)
+		}
+		if buf.String() != tt.expected {
+			t.Errorf("Render() got = %q, want %q", buf.String(), tt.expected)
+		}
+	}
+
+	for _, body := range []string{
+		`{{layouts "missing_layout.html"}}`,
+		`{{layouts "footer.html"}}`,
+		`{{layouts .Layout}}`,
+		`{{layouts "card_layout.html"}}{{layouts "card_layout.html"}}`,
+	} {
+		if _, err := New(createTestFS(testFile{"card_layout.html", `{{render}}`}, testFile{"footer.html", ""}, testFile{"invalid.html", body})); err == nil {
+			t.Errorf("New() expected error for %s, got nil", body)
+		}
+	}
+}
+
+func TestRenderAll(t *testing.T) {
+	testFS := fstest.MapFS{
+		"layout.html":          &fstest.MapFile{Data: []byte("{{render}}")},
+		"index.html":           &fstest.MapFile{Data: []byte("<h1>{{.}}</h1>")},
+		"blog/post.html":       &fstest.MapFile{Data: []byte(`<p>{{.}}</p>{{partial "partials/footer.html"}}`)},
+		"partials/footer.html": &fstest.MapFile{Data: []byte("<footer></footer>")},
+	}
+
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	dir := t.TempDir()
+	if err := engine.RenderAll(dir, func(view string) any { return view }); err != nil {
+		t.Fatalf("RenderAll() error = %v", err)
+	}
+
+	expected := map[string]string{
+		"index.html":     "<h1>index.html</h1>",
+		"blog/post.html": "<p>blog/post.html</p><footer></footer>",
+	}
+	for name, content := range expected {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("ReadFile() error = %v", err)
+			continue
+		}
+		if string(b) != content {
+			t.Errorf("RenderAll() %s got = %q, want %q", name, b, content)
+		}
+	}
+
+	// partials and layouts are not rendered
+	for _, name := range []string{"partials/footer.html", "layout.html"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("RenderAll() expected %s not to be rendered, got %v", name, err)
+		}
+	}
+}
+
+func TestRender_CachedPartial(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", "{{render}}"},
+		testFile{"table.html", `{{cachedPartial "partials/big.html" .Version .Rows}}`},
+		testFile{"partials/big.html", `<table>{{range .}}<tr>{{expensive .}}</tr>{{end}}</table>`},
+	)
+
+	var calls int
+	var mu sync.Mutex
+	funcMap := map[string]any{"expensive": func(s string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return s
+	}}
+
+	render := func(engine Engine, version int, rows ...string) string {
+		var buf bytes.Buffer
+		if err := engine.Render(&buf, "table.html", map[string]any{"Version": version, "Rows": rows}); err != nil {
+			t.Fatalf("Render() error = %v", err)
+		}
+		return buf.String()
+	}
+
+	engine := Must(New(testFS, WithLayout("layout.html"), WithFuncMap(funcMap), WithPartialCache(10, time.Minute)))
+
+	if got, expected := render(engine, 1, "a", "<b>"), "<table><tr>a</tr><tr>&lt;b&gt;</tr></table>"; got != expected {
+		t.Errorf("Render() got = %q, want %q", got, expected)
+	}
+	// cached by the key
+	if got, expected := render(engine, 1, "c"), "<table><tr>a</tr><tr>&lt;b&gt;</tr></table>"; got != expected {
+		t.Errorf("Render() got = %q, want %q", got, expected)
+	}
+	if got, expected := render(engine, 2, "c"), "<table><tr>c</tr></table>"; got != expected {
+		t.Errorf("Render() got = %q, want %q", got, expected)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+
+	// not cached without WithPartialCache
+	engine = Must(New(testFS, WithLayout("layout.html"), WithFuncMap(funcMap)))
+	if got, expected := render(engine, 1, "c"), "<table><tr>c</tr></table>"; got != expected {
+		t.Errorf("Render() got = %q, want %q", got, expected)
+	}
+
+	testFS = createTestFS(testFile{"invalid.html", `{{cachedPartial "partial.html"}}`})
+	if _, err := New(testFS); err == nil {
+		t.Errorf("New() expected error, got nil")
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"nested.html", `{{partial "partials/card.html" .}}{{partial "partial.html"}}`},
+		testFile{"partials/card.html", `{{partial "./_title.html" .}}`},
+		testFile{"partials/_title.html", `{{.}}`},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	deps, err := engine.Dependencies("nested.html")
+	if err != nil {
+		t.Fatalf("Dependencies() error = %v", err)
+	}
+	expected := []string{"partial.html", "partial2.html", "partials/_title.html", "partials/card.html"}
+	if !slices.Equal(deps, expected) {
+		t.Errorf("Dependencies() got = %v, want %v", deps, expected)
+	}
+
+	if _, err := engine.Dependencies("missing.html"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Dependencies() expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestGraph(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"card_layout.html", `<div>{{render}}</div>`},
+		testFile{"index.html", `{{define "head"}}<title>Index</title>{{end}}{{partial "nav.html"}}{{partial "footer.html"}}{{partial "nav.html"}}`},
+		testFile{"card.html", `{{layouts "layout.html" "card_layout.html"}}{{define "title"}}Card{{end}}Card`},
+		testFile{"nav.html", `Nav`},
+		testFile{"footer.html", `Footer`},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	graph, err := engine.Graph()
+	if err != nil {
+		t.Fatalf("Graph() error = %v", err)
+	}
+	expected := map[string][]string{
+		"index.html": {"layout:layout.html", "partial:footer.html", "partial:nav.html", "section:head"},
+		"card.html":  {"layout:layout.html", "layout:card_layout.html", "section:title"},
+	}
+	for view, entries := range expected {
+		if !slices.Equal(graph[view], entries) {
+			t.Errorf("Graph()[%s] got = %v, want %v", view, graph[view], entries)
+		}
+	}
+	for _, partial := range []string{"nav.html", "footer.html"} {
+		if _, ok := graph[partial]; ok {
+			t.Errorf("Graph() expected no entry for partial %s", partial)
+		}
+	}
+}
+
+func TestNew_LayoutString(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"index.html", `{{define "head"}}<title>{{.}}</title>{{end}}<p>{{.}}</p>`},
+		testFile{"nav.html", `<nav>{{.}}</nav>`},
+	)
+	body := `<html>{{render "head"}}{{partial "nav.html" .}}{{render}}</html>`
+	expected := "<html><title>John</title><nav>John</nav><p>John</p></html>"
+
+	// the option can be reused by several engines
+	option := WithLayoutString(body)
+	for _, engine := range []Engine{
+		Must(New(testFS, option)),
+		Must(New(testFS, option)),
+		Must(New(testFS, WithLayoutReader(strings.NewReader(body)))),
+	} {
+		var buf bytes.Buffer
+		if err := engine.Render(&buf, "index.html", "John"); err != nil {
+			t.Fatalf("Render() error = %v", err)
+		}
+		if buf.String() != expected {
+			t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+		}
+	}
+
+	for _, options := range [][]Option{
+		{WithLayoutString(`{{render`)},
+		{WithLayoutString(`{{partial "missing.html"}}`)},
+		{WithLayoutString(body), WithLayout("layout.html")},
+		{WithLayoutReader(nil)},
+	} {
+		if _, err := New(testFS, options...); err == nil {
+			t.Errorf("New() expected error, got nil")
+		}
+	}
+
+	_, err := New(testFS, WithLayoutString(body), WithMaxFileSize(10))
+	if err == nil || !strings.Contains(err.Error(), "error reading layout: size exceeds the limit of 10 bytes") {
+		t.Errorf("New() error = %v, want size limit error", err)
+	}
+}
+
+func TestSource(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `<main>{{render}}</main>`},
+		testFile{"button.html", `{{.label}}`},
+		testFile{"index.html", `{{partial "button.html" label="Save"}}`},
+	)
+	engine := Must(New(testFS, WithLayout("layout.html"), WithPartial("banner.html", `<b>{{.}}</b>`)))
+
+	for name, expected := range map[string]string{
+		"layout.html": `<main>{{render}}</main>`,
+		"index.html":  `{{partial "button.html" label="Save"}}`,
+		"button.html": `{{.label}}`,
+		"banner.html": `<b>{{.}}</b>`,
+	} {
+		if source, ok := engine.Source(name); !ok || source != expected {
+			t.Errorf("Source(%s) = %q, %t, want %q", name, source, ok, expected)
+		}
+	}
+	if _, ok := engine.Source("missing.html"); ok {
+		t.Errorf("Source(missing.html) expected false")
+	}
+}
+
+func TestStats(t *testing.T) {
+	testFS := fstest.MapFS{
+		"layout.html":      &fstest.MapFile{Data: []byte(`{{render}}`)},
+		"card_layout.html": &fstest.MapFile{Data: []byte(`<div>{{render}}</div>`)},
+		"index.html":       &fstest.MapFile{Data: []byte(`{{partial "nav.html"}}{{partial "banner.html"}}`)},
+		"card.html":        &fstest.MapFile{Data: []byte(`{{layouts "layout.html" "card_layout.html"}}Card`)},
+		"nav.html":         &fstest.MapFile{Data: []byte(`Nav`)},
+	}
+	engine := Must(New(testFS, WithLayout("layout.html"), WithPartial("banner.html", `Banner`)))
+
+	expected := Stats{Layouts: 2, Views: 2, Partials: 2, Bytes: 10 + 47 + 48 + 3 + 6}
+	if stats := engine.Stats(); stats != expected {
+		t.Errorf("Stats() got = %+v, want %+v", stats, expected)
+	}
+}
+
+func TestRender_DefineScoping(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"list_layout.html", `<head>{{render "head"}}</head>{{render}}{{partial "footer.html"}}`},
+		testFile{"a.html", `{{define "head"}}{{partial "meta.html" "a"}}{{end}}{{define "pagination"}}A{{end}}[{{template "pagination"}}]{{partial "pager.html"}}{{partial "list.html"}}`},
+		testFile{"b.html", `{{define "pagination"}}B{{end}}[{{template "pagination"}}]{{partial "pager.html"}}`},
+		testFile{"pager.html", `{{define "pagination"}}P{{end}}({{template "pagination"}})`},
+		testFile{"list.html", `{{define "pagination"}}L{{end}}({{template "pagination"}})`},
+		testFile{"footer.html", `{{define "pagination"}}F{{end}}({{template "pagination"}})`},
+		testFile{"meta.html", `<meta name="{{.}}">`},
+	)
+	engine := Must(New(testFS, WithLayout("list_layout.html")))
+
+	tests := []struct {
+		view     string
+		expected string
+	}{
+		{view: "a.html", expected: `<head><meta name="a"></head>[A](P)(L)(F)`},
+		{view: "b.html", expected: `<head></head>[B](P)(F)`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := engine.Render(&buf, tt.view, nil); err != nil {
+			t.Fatalf("Render(%s) error = %v", tt.view, err)
+		}
+		if buf.String() != tt.expected {
+			t.Errorf("Render(%s) got = %q, want %q", tt.view, buf.String(), tt.expected)
+		}
+	}
+}
+
+func TestRender_BodySection(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"content_layout.html", `<main>{{render "content"}}</main><aside>{{render}}</aside>`},
+		testFile{"index.html", `Hello {{.}}`},
+	)
+
+	engine := Must(New(testFS, WithLayout("content_layout.html"), WithBodySection("content")))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "index.html", "John"); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := "<main>Hello John</main><aside>Hello John</aside>"; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	if _, err := New(testFS, WithBodySection("")); err == nil {
+		t.Errorf("New() expected error, got nil")
+	}
+}
+
+func TestRender_HeadSection(t *testing.T) {
+	testFS := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`{{define "meta"}}<title>Home</title>{{end}}Hello`)},
+	}
+	engine := Must(New(testFS, WithHeadSection("meta")))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "index.html", nil); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "<head>\n    <title>Home</title>\n</head>") {
+		t.Errorf("Render() got = %q, expected the meta section in the head", buf.String())
+	}
+
+	// views must define the head section without auto head
+	if _, err := New(testFS, WithHeadSection("meta"), WithAutoHead(false)); err != nil {
+		t.Errorf("New() error = %v", err)
+	}
+	testFS["about.html"] = &fstest.MapFile{Data: []byte(`About`)}
+	expected := "error parsing view 'about.html': head section 'meta' not defined"
+	if _, err := New(testFS, WithHeadSection("meta"), WithAutoHead(false)); err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("New() error = %v, expected to contain %q", err, expected)
+	}
+
+	// unless the layout defines a default
+	testFS["layout.html"] = &fstest.MapFile{Data: []byte(`{{define "head"}}<title>Site</title>{{end}}{{render "head"}}|{{render}}`)}
+	engine = Must(New(testFS, WithLayout("layout.html"), WithAutoHead(false)))
+	buf.Reset()
+	if err := engine.Render(&buf, "about.html", nil); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := "<title>Site</title>|About"; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	for _, name := range []string{"", "body"} {
+		if _, err := New(testFS, WithHeadSection(name)); err == nil {
+			t.Errorf("New() with head section %q expected error, got nil", name)
+		}
+	}
+}
+
+func TestRender_OptionalPartial(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", "{{render}}"},
+		testFile{"optional.html", `{{optionalPartial "partial.html" .Location}}|{{if false}}{{optionalPartial "missing.html" .}}{{end}}{{optionalPartial "missing.html"}}|`},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "optional.html", map[string]any{"Location": "Earth"}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := "Location: Earth||"; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	// partials added afterwards are not resolved until the engine is built again
+	testFS.(fstest.MapFS)["missing.html"] = &fstest.MapFile{Data: []byte("added")}
+	if err := engine.Reload("optional.html"); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	buf.Reset()
+	if err := engine.Render(&buf, "optional.html", map[string]any{"Location": "Earth"}); err != nil || buf.String() != "Location: Earth||" {
+		t.Errorf("Render() after Reload() got = %q, %v, want %q", buf.String(), err, "Location: Earth||")
+	}
+	engine = Must(New(testFS, WithLayout("layout.html")))
+	buf.Reset()
+	if err := engine.Render(&buf, "optional.html", map[string]any{"Location": "Earth"}); err != nil || buf.String() != "Location: Earth|added|" {
+		t.Errorf("Render() after New() got = %q, %v, want %q", buf.String(), err, "Location: Earth|added|")
+	}
+}
+
+func TestRender_Minify(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", "<main>\n  {{render}}\n</main>\n"},
+		testFile{"index.html", "<p>\n  Hello   {{.}}\n</p>"},
+	)
+
+	var buf bytes.Buffer
+	engine := Must(New(testFS, WithLayout("layout.html"), WithMinify(true)))
+	if err := engine.Render(&buf, "index.html", "John"); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := "<main><p> Hello John </p></main>"; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	minifier := func(b []byte) ([]byte, error) { return bytes.ToUpper(b), nil }
+	engine = Must(New(testFS, WithLayout("layout.html"), WithMinifier(minifier)))
+	buf.Reset()
+	if err := engine.Render(&buf, "index.html", "John"); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := "<MAIN>\n  <P>\n  HELLO   JOHN\n</P>\n</MAIN>\n"; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	minifier = func(b []byte) ([]byte, error) { return nil, errors.New("minify error") }
+	engine = Must(New(testFS, WithLayout("layout.html"), WithMinifier(minifier)))
+	if err := engine.Render(io.Discard, "index.html", "John"); err == nil {
+		t.Errorf("Render() expected error, got nil")
+	}
+}
+
+func TestRender_FuncError(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `{{render}}{{render "aside" (mustLookup .Aside)}}`},
+		testFile{"user.html", `{{partial "partials/user.html" (mustLookup .ID)}}`},
+		testFile{"aside.html", `{{define "aside"}}{{.}}{{end}}`},
+		testFile{"inline.html", `{{mustLookup .ID}}`},
+		testFile{"partials/user.html", `<b>{{.}}</b>`},
+	)
+
+	errLookup := errors.New("lookup failed")
+	users := map[string]string{"1": "John"}
+	funcMap := map[string]any{"mustLookup": func(id string) (string, error) {
+		if user, ok := users[id]; ok {
+			return user, nil
+		}
+		return "", errLookup
+	}}
+
+	engine := Must(New(testFS, WithLayout("layout.html"), WithFuncMap(funcMap)))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "user.html", map[string]any{"ID": "1", "Aside": "1"}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := "<b>John</b>"; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	tests := []struct {
+		view string
+		data map[string]any
+	}{
+		{view: "user.html", data: map[string]any{"ID": "2", "Aside": "1"}},
+		{view: "aside.html", data: map[string]any{"Aside": "2"}},
+		{view: "inline.html", data: map[string]any{"ID": "2", "Aside": "1"}},
+	}
+	for _, tt := range tests {
+		if err := engine.Render(io.Discard, tt.view, tt.data); !errors.Is(err, errLookup) {
+			t.Errorf("Render(%s) expected errLookup, got %v", tt.view, err)
+		}
+	}
+}
+
+type testUser struct {
+	Name  string
+	Email string
+}
+
+func (testUser) Initials() string { return "JD" }
+
+func TestRender_StrictPartialArgs(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", "{{render}}"},
+		testFile{"user.html", `{{partial "partials/user.html" .User}}`},
+		testFile{"partials/user.html", "{{/* expects: .Name .Email .Initials */}}\n{{.Name}} {{.Email}} {{.Initials}}{{with $.Email}}{{.}}{{end}}"},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html"), WithStrictPartialArgs(true)))
+
+	tests := []struct {
+		user     any
+		expected string
+		err      bool
+	}{
+		{user: testUser{Name: "John", Email: "j@d"}, expected: "\nJohn j@d JDj@d"},
+		{user: &testUser{Name: "John", Email: "j@d"}, expected: "\nJohn j@d JDj@d"},
+		{user: map[string]any{"Name": "John", "Email": "j@d", "Initials": "JD"}, expected: "\nJohn j@d JDj@d"},
+		{user: map[string]any{"Name": "John"}, err: true},
+		{user: struct{ Name, Email string }{}, err: true},
+		{user: nil, err: true},
+	}
+	for i, tt := range tests {
+		var buf bytes.Buffer
+		err := engine.Render(&buf, "user.html", map[string]any{"User": tt.user})
+		if tt.err {
+			if err == nil || !strings.Contains(err.Error(), "partial 'partials/user.html' expects field") {
+				t.Errorf("%d: Render() expected error, got %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: Render() error = %v", i, err)
+		} else if buf.String() != tt.expected {
+			t.Errorf("%d: Render() got = %q, want %q", i, buf.String(), tt.expected)
+		}
+	}
+
+	testFS = createTestFS(testFile{"partials/user.html", "{{/* expects: .Name */}}\n{{.Name}} {{.Phone}}{{with .Name}}{{.Email}}{{end}}"})
+	_, err := New(testFS, WithStrictPartialArgs(true))
+	if expected := "partials/user.html:2:13: field .Phone not declared with expects"; err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("New() error = %v, expected to contain %q", err, expected)
+	}
+	if err != nil && strings.Contains(err.Error(), ".Email") {
+		t.Errorf("New() error = %v, expected fields within with blocks to be ignored", err)
+	}
+}
+
+func TestNew_BaseTemplate(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `[[render]]`},
+		testFile{"base.html", `([[template "shared" .]])`},
+	)
+
+	base := template.Must(template.New("base").Delims("[[", "]]").Funcs(template.FuncMap{"shout": strings.ToUpper}).Parse(`[[define "shared"]]<b>[[shout .]]</b>[[end]]`))
+	engine := Must(New(testFS, WithLayout("layout.html"), WithBaseTemplate(base), WithStrictSections(true)))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "base.html", "mold"); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	// delimiters are inherited from the base
+	if expected := "(<b>MOLD</b>)"; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	executed := template.Must(template.New("base").Parse(""))
+	_ = executed.Execute(io.Discard, nil)
+	if _, err := New(testFS, WithBaseTemplate(executed)); err == nil {
+		t.Errorf("New() expected error for executed base template, got nil")
+	}
+	if _, err := New(testFS, WithBaseTemplate(base), WithTextMode(true)); err == nil {
+		t.Errorf("New() expected error in text mode, got nil")
+	}
+}
+
+func TestRender_ExecErrorLocation(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"my_layout.html", "<html>\n{{render}}\n{{.Layout.Field}}</html>"},
+		testFile{"index.html", "<p>\n  {{.View.Field}}</p>{{partial \"partials/card.html\" .}}"},
+		testFile{"partials/card.html", "\n\n{{.Partial.Field}}"},
+	)
+
+	engine := Must(New(testFS, WithLayout("my_layout.html")))
+
+	tests := []struct {
+		data     map[string]any
+		expected string
+	}{
+		{data: map[string]any{"View": 1}, expected: "index.html:2:9"},
+		{data: map[string]any{"View": map[string]any{}, "Partial": 1}, expected: "partials/card.html:3:10"},
+		{data: map[string]any{"View": map[string]any{}, "Partial": map[string]any{}, "Layout": 1}, expected: "my_layout.html:3:9"},
+	}
+	for _, tt := range tests {
+		err := engine.Render(io.Discard, "index.html", tt.data)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("Render() error = %v, expected to contain %q", err, tt.expected)
+		}
+	}
+}
+
+func TestRender_EachPartial(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", "{{render}}"},
+		testFile{"list.html", `<ul>{{eachPartial "partials/item.html" .Items}}</ul>{{eachPartial "partials/item.html" .Empty}}`},
+		testFile{"partials/item.html", `<li>{{.}}</li>`},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "list.html", map[string]any{"Items": []string{"a", "<b>"}}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := "<ul><li>a</li><li>&lt;b&gt;</li></ul>"; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	for _, body := range []string{`{{eachPartial "partials/item.html"}}`, `{{eachPartial "missing.html" .}}`} {
+		if _, err := New(createTestFS(testFile{"partials/item.html", ""}, testFile{"invalid.html", body})); err == nil {
+			t.Errorf("New() expected error for %s, got nil", body)
+		}
+	}
+}
+
+func TestRenderSection(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", "<html>{{render}}</html>"},
+		testFile{"email.html", `{{define "subject"}}Hi {{.}}{{end}}{{define "email_body"}}<p>Hello {{.}}</p>{{end}}Body`},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	tests := []struct {
+		section  string
+		expected string
+	}{
+		{section: "email_body", expected: "<p>Hello John</p>"},
+		{section: "subject", expected: "Hi John"},
+		{section: "body", expected: "Body"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := engine.RenderSection(&buf, "email.html", tt.section, "John"); err != nil {
+			t.Fatalf("RenderSection() error = %v", err)
+		}
+		if buf.String() != tt.expected {
+			t.Errorf("RenderSection() got = %q, want %q", buf.String(), tt.expected)
+		}
+	}
+
+	for _, tt := range []struct{ view, section string }{{"email.html", "missing"}, {"missing.html", "subject"}} {
+		if err := engine.RenderSection(io.Discard, tt.view, tt.section, nil); !errors.Is(err, ErrNotFound) {
+			t.Errorf("RenderSection(%s, %s) expected ErrNotFound, got %v", tt.view, tt.section, err)
+		}
+	}
+}
+
+func TestRenderWithLayout(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `<html>{{render "head"}}{{render}}</html>`},
+		testFile{"print_layout.html", `<print>{{render}}</print>`},
+		testFile{"article.html", `{{define "head"}}<title>{{.}}</title>{{end}}<p>{{.}}</p>`},
+		testFile{"card.html", `{{layouts "layout.html"}}<div>{{.}}</div>`},
+	)
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	tests := []struct {
+		layout   string
+		view     string
+		expected string
+	}{
+		{layout: "print_layout.html", view: "article.html", expected: "<print><p>John</p></print>"},
+		{layout: "layout.html", view: "article.html", expected: "<html><title>John</title><p>John</p></html>"},
+		{layout: "print_layout.html", view: "card.html", expected: "<print><div>John</div></print>"},
+		// cached by layout and view
+		{layout: "print_layout.html", view: "article.html", expected: "<print><p>John</p></print>"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := engine.RenderWithLayout(&buf, tt.layout, tt.view, "John"); err != nil {
+			t.Fatalf("RenderWithLayout(%s, %s) error = %v", tt.layout, tt.view, err)
+		}
+		if buf.String() != tt.expected {
+			t.Errorf("RenderWithLayout(%s, %s) got = %q, want %q", tt.layout, tt.view, buf.String(), tt.expected)
+		}
+	}
+
+	// the configured layout is unaffected
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "article.html", "John"); err != nil || buf.String() != "<html><title>John</title><p>John</p></html>" {
+		t.Errorf("Render() got = %q, %v", buf.String(), err)
+	}
+
+	if err := engine.RenderWithLayout(io.Discard, "print_layout.html", "missing.html", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RenderWithLayout() expected ErrNotFound, got %v", err)
+	}
+	if err := engine.RenderWithLayout(io.Discard, "missing_layout.html", "article.html", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RenderWithLayout() with a missing layout expected ErrNotFound, got %v", err)
+	}
+	for _, layout := range []string{"missing_layout.html", "article.html"} {
+		if err := engine.RenderWithLayout(io.Discard, layout, "article.html", nil); err == nil {
+			t.Errorf("RenderWithLayout(%s) expected error, got nil", layout)
+		}
+	}
+}
+
+func TestRenderWithOverrides(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"index.html", `{{partial "card.html" .}}|{{cachedPartial "card.html" "key" .}}`},
+		testFile{"card.html", `Card {{.}}`},
+		testFile{"card_v2.html", `Card v2 {{.}} {{partial "icon.html"}}`},
+		testFile{"icon.html", `*`},
+		testFile{"age.html", `Age v2: {{.}}`},
+		testFile{"list.html", `{{partial "card.html" .}}`},
+	)
+	engine := Must(New(testFS, WithLayout("layout.html"), WithPartialCache(0, 0)))
+	data := map[string]any{"Age": 30}
+
+	tests := []struct {
+		overrides Overrides
+		expected  string
+	}{
+		{overrides: nil, expected: "Card map[Age:30]|Card map[Age:30]<br>Age: 30"},
+		{overrides: Overrides{"card.html": "card_v2.html"}, expected: "Card v2 map[Age:30] *|Card v2 map[Age:30] *<br>Age: 30"},
+		// partials of the layout are overridden too
+		{overrides: Overrides{"partial2.html": "age.html"}, expected: "Card map[Age:30]|Card map[Age:30]<br>Age v2: 30"},
+		{overrides: Overrides{}, expected: "Card map[Age:30]|Card map[Age:30]<br>Age: 30"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := engine.RenderWithOverrides(&buf, "index.html", data, tt.overrides); err != nil {
+			t.Fatalf("RenderWithOverrides(%v) error = %v", tt.overrides, err)
+		}
+		if expected := "<html><body>" + tt.expected + "</body></html>"; buf.String() != expected {
+			t.Errorf("RenderWithOverrides(%v) got = %q, want %q", tt.overrides, buf.String(), expected)
+		}
+	}
+
+	for _, overrides := range []Overrides{
+		{"card.html": "missing.html"},
+		{"missing.html": "card.html"},
+		{"card.html": "list.html"},
+	} {
+		if err := engine.RenderWithOverrides(io.Discard, "index.html", data, overrides); err == nil {
+			t.Errorf("RenderWithOverrides(%v) expected error, got nil", overrides)
+		}
+	}
+
+	// overrides referencing each other are rejected before execution
+	overrides := Overrides{"card.html": "card_v2.html", "icon.html": "list.html"}
+	err := engine.RenderWithOverrides(io.Discard, "index.html", data, overrides)
+	if err == nil || !strings.Contains(err.Error(), "cyclic reference") {
+		t.Errorf("RenderWithOverrides(%v) error = %v, expected a cyclic reference", overrides, err)
+	}
+}
+
+func TestRender_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	testFS := createTestFS(
+		testFile{"fast.html", `Fast {{.}}`},
+		testFile{"slow.html", `Slow {{wait}} {{.}}`},
+	)
+	funcs := template.FuncMap{"wait": func() string { <-release; return "" }}
+	engine := Must(New(testFS, WithFuncMap(funcs), WithRenderTimeout(10*time.Millisecond)))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "fast.html", "John"); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "Fast John") {
+		t.Errorf("Render() got = %q, expected to contain %q", buf.String(), "Fast John")
+	}
+
+	buf.Reset()
+	err := engine.Render(&buf, "slow.html", "John")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Render() expected deadline exceeded, got %v", err)
+	}
+	if buf.Len() > 0 {
+		t.Errorf("Render() expected no output, got %q", buf.String())
+	}
+
+	if _, err := New(testFS, WithRenderTimeout(-time.Second)); err == nil {
+		t.Errorf("New() expected error, got nil")
+	}
+}
+
+func TestRender_LayoutFileHint(t *testing.T) {
+	testFS := createTestFS(testFile{"user_layout.html", `<p>{{.}}</p>`})
+	engine := Must(New(testFS))
+
+	err := engine.Render(io.Discard, "user_layout.html", nil)
+	if !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "looks like a layout file") {
+		t.Errorf("Render() error = %v, expected ErrNotFound with a hint", err)
+	}
+
+	err = engine.Render(io.Discard, "missing.html", nil)
+	if !errors.Is(err, ErrNotFound) || strings.Contains(err.Error(), "looks like a layout file") {
+		t.Errorf("Render() error = %v, expected ErrNotFound without a hint", err)
+	}
+}
+
+func TestNew_DuplicatePolicy(t *testing.T) {
+	testFS := createTestFS(testFile{"View.html", "Duplicate"})
+
+	if _, err := New(testFS, WithDuplicatePolicy(DuplicateError)); err == nil || !strings.Contains(err.Error(), "'View.html'") {
+		t.Errorf("New() expected duplicate error, got %v", err)
+	}
+
+	engine, err := New(testFS, WithDuplicatePolicy(DuplicateLastWins))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := engine.Render(io.Discard, "View.html", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Render() expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := New(testFS); err != nil {
+		t.Errorf("New() error = %v", err)
+	}
+}
+
+func TestRenderWith(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `<script nonce="{{nonce}}"></script>{{render}}`},
+		testFile{"view.html", `{{partial "partial.html" .}}`},
+		testFile{"partial.html", `<style nonce="{{nonce}}"></style>`},
+	)
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nonce := fmt.Sprint("n", i)
+			var buf bytes.Buffer
+			if err := engine.RenderWith(&buf, "view.html", nil, map[string]any{"nonce": nonce}); err != nil {
+				t.Errorf("RenderWith() error = %v", err)
+				return
+			}
+			expected := `<script nonce="` + nonce + `"></script><style nonce="` + nonce + `"></style>`
+			if buf.String() != expected {
+				t.Errorf("RenderWith() got = %q, want %q", buf.String(), expected)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "view.html", nil); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	expected := `<script nonce=""></script><style nonce=""></style>`
+	if buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	textEngine := Must(New(testFS, WithLayout("layout.html"), WithTextMode(true)))
+	buf.Reset()
+	if err := textEngine.RenderWith(&buf, "view.html", nil, map[string]any{"nonce": "abc"}); err != nil {
+		t.Fatalf("RenderWith() error = %v", err)
+	}
+	expected = `<script nonce="abc"></script><style nonce="abc"></style>`
+	if buf.String() != expected {
+		t.Errorf("RenderWith() got = %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestRender_Sanitizer(t *testing.T) {
+	sanitize := func(s string) string {
+		return strings.NewReplacer("<script>", "", "</script>", "").Replace(s)
+	}
+	testFS := createTestFS(
+		testFile{"layout.html", `{{render}}`},
+		testFile{"index.html", `<div>{{sanitize .}}</div>`},
+	)
+	engine := Must(New(testFS, WithLayout("layout.html"), WithSanitizer(sanitize)))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "index.html", "<b>bold</b><script>alert(1)</script>"); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := "<div><b>bold</b>alert(1)</div>"; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	if _, err := New(testFS, WithSanitizer(nil)); err == nil {
+		t.Errorf("New() expected error, got nil")
+	}
+	if _, err := New(testFS, WithLayout("layout.html")); err == nil {
+		t.Errorf("New() expected error for undefined function \"sanitize\", got nil")
+	}
+}
+
+func TestRender_FuncMapFactory(t *testing.T) {
+	factory := func(rc RenderContext) template.FuncMap {
+		return template.FuncMap{
+			"describe": func() string {
+				user, _ := rc.Values["user"].(string)
+				return fmt.Sprintf("%s:%v:%s:%t", rc.View, rc.Data, user, rc.Context != nil)
+			},
+		}
+	}
+	testFS := createTestFS(
+		testFile{"layout.html", `{{render}}`},
+		testFile{"print_layout.html", `print {{render}}`},
+		testFile{"index.html", `{{describe}}`},
+	)
+	engine := Must(New(testFS, WithLayout("layout.html"), WithFuncMapFactory(factory)))
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf bytes.Buffer
+			if err := engine.RenderWith(&buf, "index.html", i, map[string]any{"user": "john"}); err != nil {
+				t.Errorf("RenderWith() error = %v", err)
+			}
+			if expected := fmt.Sprintf("index.html:%d:john:true", i); buf.String() != expected {
+				t.Errorf("RenderWith() got = %q, want %q", buf.String(), expected)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var buf bytes.Buffer
+	if err := engine.RenderWithLayout(&buf, "print_layout.html", "index.html", "data"); err != nil {
+		t.Fatalf("RenderWithLayout() error = %v", err)
+	}
+	if expected := "print index.html:data::true"; buf.String() != expected {
+		t.Errorf("RenderWithLayout() got = %q, want %q", buf.String(), expected)
+	}
+
+	// the factory is not called for views not depending on the render
+	var calls atomic.Int32
+	counting := func(rc RenderContext) template.FuncMap {
+		calls.Add(1)
+		return factory(rc)
+	}
+	testFS.(fstest.MapFS)["static.html"] = &fstest.MapFile{Data: []byte(`static`)}
+	engine = Must(New(testFS, WithLayout("layout.html"), WithFuncMapFactory(counting)))
+	if err := engine.Render(io.Discard, "static.html", nil); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if err := engine.Render(io.Discard, "index.html", nil); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if n := calls.Load(); n != 2 { // once by New, once by the render of index.html
+		t.Errorf("factory called %d times, want 2", n)
+	}
+
+	// functions of the render must be of the declared type
+	mismatch := func(rc RenderContext) template.FuncMap {
+		if rc.View == "" {
+			return template.FuncMap{"describe": func() string { return "" }}
+		}
+		return template.FuncMap{"describe": func() int { return 1 }}
+	}
+	engine = Must(New(testFS, WithLayout("layout.html"), WithFuncMapFactory(mismatch)))
+	if err := engine.Render(io.Discard, "index.html", nil); err == nil || !strings.Contains(err.Error(), `function "describe" of the render is of type func() int`) {
+		t.Errorf("Render() error = %v, expected type mismatch", err)
+	}
+
+	if _, err := New(testFS, WithFuncMapFactory(nil)); err == nil {
+		t.Errorf("New() expected error, got nil")
+	}
+	conflict := WithFuncMap(template.FuncMap{"describe": func() string { return "" }})
+	if _, err := New(testFS, WithFuncMapFactory(factory), conflict); err == nil {
+		t.Errorf("New() expected error for conflicting function, got nil")
+	}
+}
+
+func TestRenderWith_Translator(t *testing.T) {
+	messages := map[string]map[string]string{
+		"en": {"welcome": "Welcome, %s!"},
+		"de": {"welcome": "Willkommen, %s!"},
+	}
+	translate := func(locale, key string, args ...any) string {
+		if locale == "" {
+			locale = "en"
+		}
+		return fmt.Sprintf(messages[locale][key], args...)
+	}
+	testFS := createTestFS(
+		testFile{"layout.html", `{{render}}`},
+		testFile{"index.html", `<h1>{{t "welcome" .}}</h1>`},
+	)
+	engine := Must(New(testFS, WithLayout("layout.html"), WithTranslator(translate)))
+
+	tests := []struct {
+		values   map[string]any
+		expected string
+	}{
+		{values: map[string]any{"locale": "de"}, expected: "<h1>Willkommen, &lt;John&gt;!</h1>"},
+		{values: map[string]any{"locale": "en"}, expected: "<h1>Welcome, &lt;John&gt;!</h1>"},
+		{values: nil, expected: "<h1>Welcome, &lt;John&gt;!</h1>"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := engine.RenderWith(&buf, "index.html", "<John>", tt.values); err != nil {
+			t.Fatalf("RenderWith() error = %v", err)
+		}
+		if buf.String() != tt.expected {
+			t.Errorf("RenderWith(%v) got = %q, want %q", tt.values, buf.String(), tt.expected)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "index.html", "John"); err != nil || buf.String() != "<h1>Welcome, John!</h1>" {
+		t.Errorf("Render() got = %q, %v", buf.String(), err)
+	}
+
+	if _, err := New(testFS, WithTranslator(nil)); err == nil {
+		t.Errorf("New() expected error, got nil")
+	}
+	if _, err := New(testFS, WithLayout("layout.html")); err == nil {
+		t.Errorf("New() expected error for undefined function \"t\", got nil")
+	}
+}
+
+func TestNew_MalformedDirectives(t *testing.T) {
+	tests := []struct {
+		file     testFile
+		layout   string
+		expected string
+	}{
+		{
+			file:     testFile{"index.html", `{{partial .Name}}`},
+			expected: "index.html:1:1: view: path to partial file must be a string literal",
+		},
+		{
+			file:     testFile{"index.html", "\n {{eachPartial 1 .Items}}"},
+			expected: "index.html:2:2: view: path to partial file must be a string literal",
+		},
+		{
+			file:     testFile{"index.html", `{{eachPartial "partial.html" .Items .}}`},
+			expected: "index.html:1:1: view: eachPartial: too many arguments",
+		},
+		{
+			file:     testFile{"layout.html", `{{render "head" . .}}`},
+			layout:   "layout.html",
+			expected: "layout:1:1: layout: render: too many arguments",
+		},
+		{
+			file:     testFile{"layout.html", `{{render . .}}`},
+			layout:   "layout.html",
+			expected: "layout:1:1: layout: section name must be a string literal",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var options []Option
+			if tt.layout != "" {
+				options = append(options, WithLayout(tt.layout))
+			}
+
+			_, err := New(createTestFS(tt.file), options...)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("New() error = %v, expected to contain %q", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNew_ErrorSnippets(t *testing.T) {
+	testFS := createTestFS(testFile{"index.html", "<h1>Title</h1>\n\t<p>{{partial .Name}}</p>\n"})
+
+	_, err := New(testFS, WithErrorSnippets(true))
+	expected := "index.html:2:5: view: path to partial file must be a string literal\n\t\t<p>{{partial .Name}}</p>\n\t\t   ^"
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("New() error = %v, expected to contain %q", err, expected)
+	}
+
+	_, err = New(testFS)
+	if err == nil || strings.Contains(err.Error(), "^") {
+		t.Errorf("New() error = %v, expected no snippet", err)
+	}
+
+	// errors of the template parser report the line, pointing at the first action on it
+	testFS = createTestFS(testFile{"index.html", "<h1>Title</h1>\n\t<p>{{if}}</p>{{end}}\n"})
+	_, err = New(testFS, WithErrorSnippets(true))
+	expected = "index.html:2:5: missing value for if\n\t\t<p>{{if}}</p>{{end}}\n\t\t   ^"
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("New() error = %v, expected to contain %q", err, expected)
+	}
+}
+
+func TestNew_TemplateError(t *testing.T) {
+	testFS := createTestFS(testFile{"index.html", "<h1>Title</h1>\n\t<p>{{partial .Name}}</p>\n"})
+
+	_, err := New(testFS)
+	var terr *TemplateError
+	if !errors.As(err, &terr) {
+		t.Fatalf("New() error = %v, expected a TemplateError", err)
+	}
+	if terr.File() != "index.html" || terr.Line() != 2 || terr.Column() != 5 || terr.Severity() != SeverityError {
+		t.Errorf("TemplateError = %s:%d:%d (%s), want index.html:2:5 (error)", terr.File(), terr.Line(), terr.Column(), terr.Severity())
+	}
+	if expected := "path to partial file must be a string literal"; terr.Message() != expected {
+		t.Errorf("Message() = %q, want %q", terr.Message(), expected)
+	}
+
+	// syntax errors of views and layouts
+	for _, tt := range []struct {
+		file    testFile
+		options []Option
+	}{
+		{file: testFile{"index.html", "<h1>Title</h1>\n\t<p>{{if}}</p>"}},
+		{file: testFile{"layout.html", "<h1>Title</h1>\n\t<p>{{if}}</p>"}, options: []Option{WithLayout("layout.html")}},
+	} {
+		_, err = New(createTestFS(tt.file), tt.options...)
+		if !errors.As(err, &terr) {
+			t.Fatalf("New() error = %v, expected a TemplateError", err)
+		}
+		if terr.File() != tt.file.name || terr.Line() != 2 || terr.Column() != 5 || terr.Message() != "missing value for if" {
+			t.Errorf("TemplateError = %s:%d:%d: %s, want %s:2:5: missing value for if", terr.File(), terr.Line(), terr.Column(), terr.Message(), tt.file.name)
+		}
+	}
+
+	// joined errors are reported individually
+	testFS = createTestFS(testFile{"index.html", "{{/* expects: .Name */}}{{.A}}\n{{.B}}"})
+	_, err = New(testFS, WithStrictPartialArgs(true))
+	var lines []int
+	var collect func(error)
+	collect = func(err error) {
+		if terr, ok := err.(*TemplateError); ok {
+			lines = append(lines, terr.Line())
+		}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, err := range joined.Unwrap() {
+				collect(err)
+			}
+		} else if err := errors.Unwrap(err); err != nil {
+			collect(err)
+		}
+	}
+	collect(err)
+	if !slices.Equal(lines, []int{1, 2}) {
+		t.Errorf("TemplateError lines = %v, want [1 2] in %v", lines, err)
+	}
+}
+
+func TestNew_PartialPathTraversal(t *testing.T) {
+	paths := []string{
+		`../secret.html`,
+		`../../etc/passwd`,
+		`./../secret.html`,
+		`pages/../../secret.html`,
+		`/etc/passwd`,
+	}
+	for _, p := range paths {
+		for _, body := range []string{
+			fmt.Sprintf(`{{partial %q}}`, p),
+			fmt.Sprintf(`{{optionalPartial %q}}`, p),
+			fmt.Sprintf(`{{partialOr "card.html" . %q}}`, p),
+		} {
+			testFS := createTestFS(
+				testFile{"secret.html", `Secret`},
+				testFile{"web/card.html", `Card`},
+				testFile{"web/index.html", body},
+			)
+			_, err := New(testFS, WithRoot("web"))
+			if err == nil || !strings.Contains(err.Error(), "must be within the root") {
+				t.Errorf("New() with %s error = %v, expected path error", body, err)
+			}
+		}
+	}
+
+	// relative paths within the root are resolved
+	testFS := createTestFS(
+		testFile{"web/card.html", `Card`},
+		testFile{"web/pages/index.html", `{{partial "../card.html"}}`},
+	)
+	engine := Must(New(testFS, WithRoot("web")))
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "pages/index.html", nil); err != nil || !strings.Contains(buf.String(), "Card") {
+		t.Errorf("Render() got = %q, %v", buf.String(), err)
+	}
+}
+
+func TestRender_Component(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `{{render}}`},
+		testFile{"components/button.html", `<button>{{.}}</button>`},
+		testFile{"components/forms/input.html", `<input value="{{index .Args 0}}" name="{{index .Args 1}}">`},
+		testFile{"pages/index.html", `{{component "button.html" .}}{{component "forms/input.html" "a" "b"}}`},
+	)
+
+	engine := Must(New(testFS, WithLayout("layout.html"), WithComponentDir("components")))
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "pages/index.html", "OK"); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := `<button>OK</button><input value="a" name="b">`; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	for _, tt := range []struct {
+		body    string
+		options []Option
+	}{
+		{body: `{{component "missing.html"}}`, options: []Option{WithComponentDir("components")}},
+		{body: `{{component "../layout.html"}}`, options: []Option{WithComponentDir("components")}},
+		{body: `{{component .Name}}`, options: []Option{WithComponentDir("components")}},
+		{body: `{{component "button.html"}}`},
+		{body: `Hello`, options: []Option{WithComponentDir("../components")}},
+	} {
+		testFS := createTestFS(
+			testFile{"components/button.html", `<button>{{.}}</button>`},
+			testFile{"index.html", tt.body},
+		)
+		if _, err := New(testFS, tt.options...); err == nil {
+			t.Errorf("New() with %s expected error, got nil", tt.body)
+		}
+	}
+}
+
+type mapResolver map[string]string
+
+func (r mapResolver) Resolve(name string) (string, bool) {
+	body, ok := r[name]
+	return body, ok
+}
+
+func TestRender_PartialResolver(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `{{partial "partials/header.html"}}{{render}}`},
+		testFile{"partials/card.html", `<child>{{.}}</child>`},
+		testFile{"index.html", `{{partial "partials/card.html" .}}{{partial "partials/footer.html" .}}{{optionalPartial "partials/none.html"}}`},
+	)
+	resolver := mapResolver{
+		"partials/header.html": `<header>`,
+		"partials/card.html":   `<parent>{{.}}</parent>`,
+		"partials/footer.html": `<footer>{{partial "./copy.html" .}}</footer>`,
+		"partials/copy.html":   `(c) {{.}}`,
+	}
+
+	engine := Must(New(testFS, WithLayout("layout.html"), WithPartialResolver(resolver)))
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "index.html", "OK"); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := `<header><child>OK</child><footer>(c) OK</footer>`; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+	if err := engine.Reload("index.html"); err != nil {
+		t.Errorf("Reload() error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		resolver PartialResolver
+	}{
+		{name: "missing", resolver: mapResolver{}},
+		{name: "invalid", resolver: mapResolver{"partials/footer.html": `{{.`, "partials/copy.html": ``}},
+		{name: "nil", resolver: nil},
+	} {
+		if _, err := New(testFS, WithPartialResolver(tt.resolver)); err == nil {
+			t.Errorf("New() with %s resolver expected error, got nil", tt.name)
+		}
+	}
+}
+
+func TestRender_Theme(t *testing.T) {
+	testFS := fstest.MapFS{
+		"themes/parent/layout.html":          {Data: []byte(`<parent>{{render}}</parent>`)},
+		"themes/parent/index.html":           {Data: []byte(`{{partial "partials/header.html"}}{{partial "partials/footer.html"}}`)},
+		"themes/parent/about.html":           {Data: []byte(`about`)},
+		"themes/parent/partials/header.html": {Data: []byte(`parent header|`)},
+		"themes/parent/partials/footer.html": {Data: []byte(`parent footer`)},
+		"themes/child/partials/header.html":  {Data: []byte(`child header|`)},
+		"themes/child/contact.html":          {Data: []byte(`contact`)},
+		"themes/file.html":                   {Data: []byte(``)},
+	}
+
+	engine := Must(New(testFS, WithTheme("themes/child", "themes/parent"), WithLayout("layout.html")))
+	for view, expected := range map[string]string{
+		"index.html":   `<parent>child header|parent footer</parent>`,
+		"about.html":   `<parent>about</parent>`,
+		"contact.html": `<parent>contact</parent>`,
+	} {
+		var buf bytes.Buffer
+		if err := engine.Render(&buf, view, nil); err != nil {
+			t.Fatalf("Render(%s) error = %v", view, err)
+		}
+		if buf.String() != expected {
+			t.Errorf("Render(%s) got = %q, want %q", view, buf.String(), expected)
+		}
+	}
+
+	for _, themes := range [][]string{
+		{},
+		{"themes/child", "themes/missing"},
+		{"themes/file.html"},
+	} {
+		if _, err := New(testFS, WithTheme(themes...)); err == nil {
+			t.Errorf("New() with themes %v expected error, got nil", themes)
+		}
+	}
+}
+
+func TestRender_KeywordArgs(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `{{render}}`},
+		testFile{"components/button.html", `<button class="{{.kind}}">{{.label}}</button>`},
+		testFile{"index.html", `{{partial "components/button.html" label="Save" kind=.Kind}}{{component "button.html" label=(printf "%d" 1) kind="x"}}`},
+	)
+	engine := Must(New(testFS, WithLayout("layout.html"), WithComponentDir("components")))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "index.html", map[string]any{"Kind": "primary"}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := `<button class="primary">Save</button><button class="x">1</button>`; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	for _, body := range []string{
+		`{{partial "components/button.html" . label="Save"}}`,
+		`{{partial "components/button.html" label="Save" label="Cancel"}}`,
+	} {
+		testFS := createTestFS(
+			testFile{"components/button.html", `{{.label}}`},
+			testFile{"index.html", body},
+		)
+		if _, err := New(testFS); err == nil {
+			t.Errorf("New() with %s expected error, got nil", body)
+		}
+	}
+
+	// custom delimiters of the base template
+	testFS = createTestFS(
+		testFile{"layout.html", `[[render]]`},
+		testFile{"button.html", `<button class="[[.kind]]">[[.label]]</button>`},
+		testFile{"index.html", `[[partial "button.html" label="Save" kind=.Kind]]`},
+	)
+	engine = Must(New(testFS, WithLayout("layout.html"), WithBaseTemplate(template.New("").Delims("[[", "]]"))))
+	buf.Reset()
+	if err := engine.Render(&buf, "index.html", map[string]any{"Kind": "primary"}); err != nil {
+		t.Fatalf("Render() with custom delimiters error = %v", err)
+	}
+	if expected := `<button class="primary">Save</button>`; buf.String() != expected {
+		t.Errorf("Render() with custom delimiters got = %q, want %q", buf.String(), expected)
+	}
+
+	// errors are reported at positions in the source
+	testFS = createTestFS(
+		testFile{"button.html", `{{.label}}`},
+		testFile{"index.html", "{{partial \"button.html\" label=\"Save\"}} {{partial .Name}}"},
+	)
+	_, err := New(testFS, WithErrorSnippets(true))
+	var terr *TemplateError
+	if !errors.As(err, &terr) {
+		t.Fatalf("New() error = %v, want TemplateError", err)
+	}
+	if terr.Line() != 1 || terr.Column() != 40 {
+		t.Errorf("TemplateError position = %d:%d, want 1:40", terr.Line(), terr.Column())
+	}
+	if !strings.Contains(err.Error(), `label="Save"`) {
+		t.Errorf("TemplateError snippet = %q, want source", err.Error())
+	}
+}
+
+func TestRender_Lazy(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `{{render}}`},
+		testFile{"index.html", `{{if .Show}}{{range lazy .Items}}{{.}}{{end}}|{{lazy .Items}}|{{.Items.Value}}{{end}}|{{lazy .Func}}|{{lazy .Plain}}`},
+	)
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	calls := 0
+	data := func(show bool) map[string]any {
+		return map[string]any{
+			"Show":  show,
+			"Items": Lazy(func() ([]string, error) { calls++; return []string{"a", "b"}, nil }),
+			"Func":  func() string { return "func" },
+			"Plain": "plain",
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "index.html", data(false)); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := "|func|plain"; buf.String() != expected || calls != 0 {
+		t.Errorf("Render() got = %q with %d calls, want %q with no calls", buf.String(), calls, expected)
+	}
+
+	buf.Reset()
+	if err := engine.Render(&buf, "index.html", data(true)); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := "ab|[a b]|[a b]|func|plain"; buf.String() != expected || calls != 1 {
+		t.Errorf("Render() got = %q with %d calls, want %q with 1 call", buf.String(), calls, expected)
+	}
+
+	errData := map[string]any{"Show": true, "Items": Lazy(func() (any, error) { return nil, errors.New("failed") })}
+	if err := engine.Render(io.Discard, "index.html", errData); err == nil || !strings.Contains(err.Error(), "failed") {
+		t.Errorf("Render() error = %v, expected lazy error", err)
+	}
+}
+
+func FuzzNew(f *testing.F) {
+	for _, body := range []string{
+		`{{partial "partial.html" .}}`,
+		`{{partial .Name}}`,
+		`{{partial "partial.html" . .}}`,
+		`{{render . .}}`,
+		`{{render "head" | printf "%s"}}`,
+		`{{$x := partial "partial.html"}}`,
+		`{{cachedPartial "partial.html"}}`,
+		`{{eachPartial "partial.html" $}}`,
+		`{{optionalPartial "missing.html"}}`,
+		`{{layouts "layout.html" .}}`,
+		`{{define "head"}}{{partial "view.html"}}{{end}}`,
+		`{{if .}}{{partial "partial.html" (print .)}}{{else}}{{render}}{{end}}`,
+	} {
+		f.Add(body)
+	}
+	f.Fuzz(func(t *testing.T, body string) {
+		// only errors are expected, never panics
+		_, _ = New(createTestFS(testFile{"view.html", body}))
+		_, _ = New(createTestFS(testFile{"layout.html", body}), WithLayout("layout.html"))
+	})
+}
+
+func TestRender_PartialDataArgs(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"view.html", `{{$name := .Name}}{{partial "p.html" "literal"}},{{partial "p.html" $name}},{{partial "p.html" (upper .Name)}},{{partial "p.html" 42}},{{partial "p.html" $}}`},
+		testFile{"layout.html", `{{render}}`},
+		testFile{"p.html", `[{{.}}]`},
+	)
+	funcMap := map[string]any{"upper": strings.ToUpper}
+	engine := Must(New(testFS, WithLayout("layout.html"), WithFuncMap(funcMap)))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "view.html", map[string]string{"Name": "John"}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	expected := "[literal],[John],[JOHN],[42],[map[Name:John]]"
+	if buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestRender_PartialMultipleArgs(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `{{render}}`},
+		testFile{"view.html", `{{partial "card.html" .Title "Body"}}{{cachedPartial "card.html" 1 .Title "Cached"}}`},
+		testFile{"card.html", `<h2>{{index .Args 0}}</h2><p>{{index .Args 1}}</p>`},
+	)
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "view.html", map[string]any{"Title": "Hello"}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	expected := "<h2>Hello</h2><p>Body</p><h2>Hello</h2><p>Cached</p>"
+	if buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestNew_Watch(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("layout.html", `<main>{{render}}</main>`)
+	write("view.html", `{{partial "partial.html"}}`)
+	write("partial.html", `v1`)
+
+	interval := func(c *Config) { c.watchInterval = newVal(10 * time.Millisecond) }
+	engine := Must(New(os.DirFS(dir), WithLayout("layout.html"), WithWatch(true), interval))
+	defer engine.Close()
+
+	// eventually waits for the output of the view to satisfy cond
+	eventually := func(cond func(string, error) bool) {
+		t.Helper()
+		var buf bytes.Buffer
+		var err error
+		for range 200 {
+			buf.Reset()
+			if err = engine.Render(&buf, "view.html", nil); cond(buf.String(), err) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Render() got = %q, %v after changes", buf.String(), err)
+	}
+
+	write("partial.html", `v2`)
+	write("layout.html", `<div>{{render}}</div>`)
+	eventually(func(out string, err error) bool { return out == "<div>v2</div>" })
+	m := engine.(*moldEngine)
+	m.mu.RLock()
+	if layout := m.c.layoutRaw; layout != `<div>{{render}}</div>` {
+		t.Errorf("layout after rebuild = %q, want the layout read again", layout)
+	}
+	m.mu.RUnlock()
+
+	// errors are reported until fixed, the previous templates are retained
+	write("partial.html", `{{end}}`)
+	eventually(func(out string, err error) bool { return err != nil })
+	write("partial.html", `v3`)
+	eventually(func(out string, err error) bool { return out == "<div>v3</div>" })
+
+	if err := engine.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+
+	// filesystems other than directories of the operating system are not polled
+	engine = Must(New(createTestFS(), WithWatch(true)))
+	defer engine.Close()
+	if engine.(*moldEngine).watcher != nil {
+		t.Errorf("New() expected the filesystem not to be watched")
+	}
+}
+
+func TestNew_WatchRoot(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "site"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "site", "view.html")
+	if err := os.WriteFile(path, []byte("v1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the filesystem is watched although wrapped by the root
+	interval := func(c *Config) { c.watchInterval = newVal(10 * time.Millisecond) }
+	engine := Must(New(os.DirFS(dir), WithRoot("site"), WithLayoutString("{{render}}"), WithWatch(true), interval))
+	defer engine.Close()
+
+	if err := os.WriteFile(path, []byte("v2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for range 200 {
+		buf.Reset()
+		if err := engine.Render(&buf, "view.html", nil); err == nil && buf.String() == "v2" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Render() got = %q after changes, want %q", buf.String(), "v2")
+}
+
+func TestClose(t *testing.T) {
+	engine := Must(New(createTestFS(), WithPartialCache(0, 0)))
+
+	for range 2 {
+		if err := engine.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "view.html", map[string]any{"Name": "John Doe", "Location": "Mars", "Age": 30}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	expected := "Hello, John Doe!<br>Location: Mars"
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("Render() got = %q, expected to contain %q", buf.String(), expected)
+	}
+}
+
+func TestRender_SourceComments(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `<head>{{render "head"}}</head>{{render}}`},
+		testFile{"view.html", `{{define "head"}}<title>{{.Name}}</title>{{end}}{{partial "card.html" .Name}}{{eachPartial "item.html" .Items}}`},
+		testFile{"card.html", `<p>{{.}}</p>`},
+		testFile{"item.html", `<li>{{.}}</li>`},
+	)
+	engine := Must(New(testFS, WithLayout("layout.html"), WithSourceComments(true)))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "view.html", map[string]any{"Name": "mold", "Items": []string{"a"}}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	expected := "<head><!-- section: head --><title>mold</title><!-- /section: head --></head>" +
+		"<!-- section: body --><!-- partial: card.html --><p>mold</p><!-- /partial: card.html -->" +
+		"<!-- partial: item.html --><li>a</li><!-- /partial: item.html --><!-- /section: body -->"
+	if buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	if _, err := New(testFS, WithSourceComments(true), WithMinify(true)); err == nil {
+		t.Errorf("New() expected error with minify, got nil")
+	}
+}
+
+func TestRender_PartialOr(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `{{render}}`},
+		testFile{"view.html", `{{partialOr "avatar.html" .Photo "default.html"}}`},
+		testFile{"avatar.html", `<img src="{{.}}">`},
+		testFile{"default.html", `<img src="/default.png">`},
+	).(fstest.MapFS)
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	tests := []struct {
+		data     map[string]any
+		expected string
+	}{
+		{data: map[string]any{"Photo": "/me.png"}, expected: `<img src="/me.png">`},
+		{data: map[string]any{"Photo": ""}, expected: `<img src="/default.png">`},
+		{data: map[string]any{}, expected: `<img src="/default.png">`},
+		// zero values rather than empty values
+		{data: map[string]any{"Photo": []string(nil)}, expected: `<img src="/default.png">`},
+		{data: map[string]any{"Photo": []string{}}, expected: `<img src="[]">`},
+		{data: map[string]any{"Photo": struct{ URL string }{}}, expected: `<img src="/default.png">`},
+		{data: map[string]any{"Photo": struct{ URL string }{"/me.png"}}, expected: `<img src="%7b/me.png%7d">`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := engine.Render(&buf, "view.html", tt.data); err != nil {
+			t.Fatalf("Render() error = %v", err)
+		}
+		if buf.String() != tt.expected {
+			t.Errorf("Render(%v) got = %q, want %q", tt.data, buf.String(), tt.expected)
+		}
+	}
+
+	deps, err := engine.Dependencies("view.html")
+	if err != nil || !slices.Equal(deps, []string{"avatar.html", "default.html"}) {
+		t.Errorf("Dependencies() = %v, %v, want [avatar.html default.html]", deps, err)
+	}
+
+	for body, expected := range map[string]string{
+		`{{partialOr "avatar.html" .Photo}}`:                "fallback partial is not specified",
+		`{{partialOr "avatar.html" .Photo .Fallback}}`:      "path to fallback partial file must be a string literal",
+		`{{partialOr "avatar.html" .Photo "missing.html"}}`: "'missing.html'",
+	} {
+		testFS["view.html"] = &fstest.MapFile{Data: []byte(body)}
+		if _, err := New(testFS, WithLayout("layout.html")); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("New() error = %v, expected to contain %q", err, expected)
+		}
+	}
+}
+
+// manyTemplatesFS returns a filesystem with n views, each referencing a partial.
+func manyTemplatesFS(n int) fstest.MapFS {
+	testFS := fstest.MapFS{}
+	for i := range n {
+		testFS[fmt.Sprintf("views/view%d.html", i)] = &fstest.MapFile{Data: []byte(fmt.Sprintf(
+			`{{define "head"}}<title>{{.Title}}</title>{{end}}<ul>{{range .Items}}<li>{{.Name}} {{printf "%%d" .Count}}</li>{{end}}</ul>{{partial "partials/p%d.html" .}}`, i))}
+		testFS[fmt.Sprintf("partials/p%d.html", i)] = &fstest.MapFile{Data: []byte(`{{with .User}}<p>{{.Name}}</p>{{else}}<p>guest</p>{{end}}`)}
+	}
+	return testFS
+}
+
+func TestNew_ParseWorkers(t *testing.T) {
+	testFS := manyTemplatesFS(50)
+	for i := range 10 {
+		testFS[fmt.Sprintf("invalid%d.html", i)] = &fstest.MapFile{Data: []byte("{{end}}")}
+	}
+
+	_, sequential := New(testFS)
+	if sequential == nil {
+		t.Fatalf("New() expected error, got nil")
+	}
+	for range 5 {
+		if _, err := New(testFS, WithParseWorkers(8)); err == nil || err.Error() != sequential.Error() {
+			t.Fatalf("New() error = %v, want %v", err, sequential)
+		}
+	}
+
+	if _, err := New(manyTemplatesFS(50), WithParseWorkers(8)); err != nil {
+		t.Errorf("New() error = %v", err)
+	}
+	if _, err := New(testFS, WithParseWorkers(0)); err == nil {
+		t.Errorf("New() expected error for invalid workers, got nil")
+	}
+}
+
+func BenchmarkNew(b *testing.B) {
+	testFS := manyTemplatesFS(500)
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for range b.N {
+				if _, err := New(testFS, WithParseWorkers(workers)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkNew_SimpleViews compares the assembly of views without partials nor sections,
+// taking the fast path, to views with partials and sections.
+func BenchmarkNew_SimpleViews(b *testing.B) {
+	simpleFS := fstest.MapFS{}
+	for i := range 500 {
+		simpleFS[fmt.Sprintf("views/view%d.html", i)] = &fstest.MapFile{Data: []byte(
+			`<ul>{{range .Items}}<li>{{.Name}} {{printf "%d" .Count}}</li>{{end}}</ul>`)}
+	}
+	for name, testFS := range map[string]fs.FS{"simple": simpleFS, "partials": manyTemplatesFS(250)} {
+		b.Run(name, func(b *testing.B) {
+			for range b.N {
+				if _, err := New(testFS); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRenderWith compares renders with per-render values to renders without.
+func BenchmarkRenderWith(b *testing.B) {
+	testFS := createTestFS(
+		testFile{"layout.html", `<html><head><script nonce="{{nonce}}"></script>{{render "head"}}</head><body>{{render}}</body></html>`},
+		testFile{"list.html", `{{define "head"}}<title>List</title>{{end}}<ul>{{range .}}<li>{{partial "partial.html" .}}</li>{{end}}</ul>`},
+	)
+	engine := Must(New(testFS, WithLayout("layout.html")))
+	data := []string{"a", "b", "c"}
+	values := map[string]any{"nonce": "abc"}
+
+	b.Run("Render", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if err := engine.Render(io.Discard, "list.html", data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("RenderWith", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if err := engine.RenderWith(io.Discard, "list.html", data, values); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("FuncMapFactory", func(b *testing.B) {
+		engine := Must(New(testFS, WithLayout("layout.html"), WithFuncMapFactory(func(rc RenderContext) template.FuncMap {
+			return template.FuncMap{"view": func() string { return rc.View }}
+		})))
+		b.ReportAllocs()
+		for range b.N {
+			if err := engine.Render(io.Discard, "list.html", data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkRender_Parallel measures concurrent renders of cached views.
+func BenchmarkRender_Parallel(b *testing.B) {
+	testFS := createTestFS(
+		testFile{"layout.html", `<html><body>{{render}}</body></html>`},
+		testFile{"list.html", `<ul>{{range .}}<li>{{partial "partial.html" .}}</li>{{end}}</ul>`},
+	)
+	data := []string{"a", "b", "c"}
+	for name, options := range map[string][]Option{
+		"default":  {WithLayout("layout.html")},
+		"caseFold": {WithLayout("layout.html"), WithCaseInsensitiveLookup(true)},
+	} {
+		engine := Must(New(testFS, options...))
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := engine.Render(io.Discard, "list.html", data); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestRender_SimpleView(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", `<main>{{render}}</main><script nonce="{{nonce}}"></script>`},
+		testFile{"simple.html", `Hello {{.}}`},
+	)
+	engine := Must(New(testFS, WithLayout("layout.html")))
+
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, "simple.html", "John"); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if expected := `<main>Hello John</main><script nonce=""></script>`; buf.String() != expected {
+		t.Errorf("Render() got = %q, want %q", buf.String(), expected)
+	}
+
+	// the prototype of the view is cloned before its first execution
+	buf.Reset()
+	if err := engine.RenderWith(&buf, "simple.html", "John", map[string]any{"nonce": "abc"}); err != nil {
+		t.Fatalf("RenderWith() error = %v", err)
+	}
+	if expected := `<main>Hello John</main><script nonce="abc"></script>`; buf.String() != expected {
+		t.Errorf("RenderWith() got = %q, want %q", buf.String(), expected)
+	}
+
+	// only views depending on the render are executed by copies
+	engine = Must(New(testFS, WithLayoutString("<main>{{render}}</main>")))
+	if err := engine.RenderWith(io.Discard, "simple.html", "John", map[string]any{"nonce": "abc"}); err != nil {
+		t.Fatalf("RenderWith() error = %v", err)
+	}
+	if v, err := engine.(*moldEngine).lookup("simple.html"); err != nil || v.scoped {
+		t.Errorf("lookup() got view depending on the render, expected none, error = %v", err)
+	}
+}
+
+func TestRenderText(t *testing.T) {
+	testFS := createTestFS(testFile{"layout.html", "<main>{{render}}</main>"})
+	engine := Must(New(testFS, WithLayout("layout.html"), WithFuncMap(template.FuncMap{"upper": strings.ToUpper})))
+
+	var buf bytes.Buffer
+	if err := engine.RenderText(&buf, `Hi {{upper .Name}}, {{partial "partial.html" .Location}}`, map[string]any{"Name": "john", "Location": "Mars"}); err != nil {
+		t.Fatalf("RenderText() error = %v", err)
+	}
+	if expected := "<main>Hi JOHN, Location: Mars</main>"; buf.String() != expected {
+		t.Errorf("RenderText() got = %q, want %q", buf.String(), expected)
+	}
+
+	for _, body := range []string{`{{partial "<text>"}}`, `{{partial "missing.html"}}`, `{{end}}`} {
+		if err := engine.RenderText(io.Discard, body, nil); err == nil {
+			t.Errorf("RenderText(%q) expected error, got nil", body)
+		}
+	}
+
+	// the engine is not modified
+	if err := engine.Render(io.Discard, "<text>", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Render() expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestHas(t *testing.T) {
+	testFS := createTestFS(testFile{"layout.html", "{{render}}"}, testFile{"docs/about.md", "# About"})
+	engine := Must(New(testFS, WithLayout("layout.html"), WithAlias("home.html", "view.html"), WithMarkdown(func(b []byte) ([]byte, error) { return b, nil })))
+
+	for view, expected := range map[string]bool{
+		"view.html":     true,
+		"home.html":     true,
+		"partial.html":  true,
+		"docs/about.md": false,
+		"layout.html":   false,
+		"missing.html":  false,
+	} {
+		if got := engine.Has(view); got != expected {
+			t.Errorf("Has(%q) = %v, want %v", view, got, expected)
+		}
+	}
+
+	views := engine.Views()
+	if !slices.Equal(views, []string{"partial2.html", "view.html"}) {
+		t.Errorf("Views() = %v", views)
+	}
+
+	// views are reported by Has, as are partials and aliases, which Views does not list
+	for _, view := range views {
+		if !engine.Has(view) {
+			t.Errorf("Has(%q) = false for a view listed by Views()", view)
+		}
+	}
+	for _, name := range []string{"partial.html", "home.html"} {
+		if !engine.Has(name) || slices.Contains(views, name) {
+			t.Errorf("Has(%q) = %v, Views() = %v, want reported by Has only", name, engine.Has(name), views)
+		}
+	}
+}
+
+func TestNew_ViewFuncMap(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"layout.html", "{{render}}"},
+		testFile{"report.html", `{{money .Total}} {{partial "row.html" .}}`},
+		testFile{"invoice.html", `{{money .Total}}`},
+		testFile{"row.html", `({{money .Total}})`},
+	).(fstest.MapFS)
+	options := With(
+		WithLayout("layout.html"),
+		WithViewFuncMap("report.html", template.FuncMap{"money": func(v int) string { return fmt.Sprintf("$%d", v) }}),
+		WithViewFuncMap("invoice.html", template.FuncMap{"money": func(v int) string { return fmt.Sprintf("%d EUR", v) }}),
+	)
+	engine := Must(New(testFS, options))
+
+	for view, expected := range map[string]string{
+		"report.html":  "$5 ($5)",
+		"invoice.html": "5 EUR",
+	} {
+		var buf bytes.Buffer
+		if err := engine.Render(&buf, view, map[string]any{"Total": 5}); err != nil {
+			t.Fatalf("Render() error = %v", err)
+		}
+		if buf.String() != expected {
+			t.Errorf("Render(%q) got = %q, want %q", view, buf.String(), expected)
+		}
+	}
+	if engine.Has("row.html") {
+		t.Errorf("Has() expected partial of the view not to be a view")
+	}
+
+	// other views cannot call the functions, even through partials
+	testFS["view.html"] = &fstest.MapFile{Data: []byte(`{{partial "row.html" .}}`)}
+	if _, err := New(testFS, options); err == nil || !strings.Contains(err.Error(), "error parsing view 'view.html': functions not available in the view: money") {
+		t.Errorf("New() expected unavailable function error, got %v", err)
+	}
+
+	if _, err := New(createTestFS(), WithViewFuncMap("missing.html", template.FuncMap{"f": strings.ToUpper})); !errors.Is(err, ErrNotFound) {
+		t.Errorf("New() expected ErrNotFound, got %v", err)
+	}
+	if _, err := New(createTestFS(), WithFuncMap(template.FuncMap{"f": strings.ToUpper}), WithViewFuncMap("view.html", template.FuncMap{"f": strings.ToLower})); err == nil {
+		t.Errorf("New() expected conflict error, got nil")
+	}
+}
+
+func TestNew_MaxFileSize(t *testing.T) {
+	testFS := createTestFS(
+		testFile{"big.html", strings.Repeat("x", 101)},
+		testFile{"icon.svg", strings.Repeat("x", 101)},
+		testFile{"include.html", `{{include "icon.svg"}}`},
+	)
+
+	_, err := New(testFS, WithMaxFileSize(100))
+	if err == nil || !strings.Contains(err.Error(), "error reading template 'big.html'") || !strings.Contains(err.Error(), "exceeds the limit of 100 bytes") {
+		t.Errorf("New() expected file size error, got %v", err)
+	}
+
+	engine := Must(New(testFS, WithMaxFileSize(101)))
+	if err := engine.Render(io.Discard, "include.html", nil); err != nil {
+		t.Errorf("Render() error = %v", err)
+	}
+
+	testFS.(fstest.MapFS)["big.html"] = &fstest.MapFile{Data: []byte("small")}
+	engine = Must(New(testFS, WithMaxFileSize(100)))
+	if err := engine.Render(io.Discard, "include.html", nil); err == nil || !strings.Contains(err.Error(), "exceeds the limit") {
+		t.Errorf("Render() expected file size error for included file, got %v", err)
+	}
+
+	if _, err := New(createTestFS(), WithMaxFileSize(-1)); err == nil {
+		t.Errorf("New() expected error for negative size, got nil")
+	}
+}
@@ -0,0 +1,84 @@
+// Package moldtest provides helpers for testing templates rendered by a [mold.Engine].
+//
+//	func TestIndex(t *testing.T) {
+//	    engine := mold.Must(mold.New(os.DirFS("web")))
+//	    moldtest.AssertRender(t, engine, "index.html", data, "<h1>Hello</h1>")
+//	    moldtest.AssertGolden(t, engine, "about.html", data, "testdata/about.golden")
+//	}
+package moldtest
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deniskrumko/mold"
+)
+
+// Update configures whether golden files are written with the rendered output instead of compared,
+// typically bound to a flag of the test package.
+//
+//	func init() {
+//	    flag.BoolVar(&moldtest.Update, "update", false, "update golden files")
+//	}
+var Update = false
+
+// RenderString renders the view with the data and returns the output as a string.
+func RenderString(engine mold.Engine, view string, data any) (string, error) {
+	var buf bytes.Buffer
+	if err := engine.Render(&buf, view, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// AssertRender reports a test failure if the view does not render to the expected output.
+func AssertRender(t testing.TB, engine mold.Engine, view string, data any, expected string) {
+	t.Helper()
+
+	out, err := RenderString(engine, view, data)
+	if err != nil {
+		t.Errorf("error rendering '%s': %v", view, err)
+		return
+	}
+	if out != expected {
+		t.Errorf("rendering '%s' got = %q, want %q", view, out, expected)
+	}
+}
+
+// AssertGolden reports a test failure if the view does not render to the contents of the golden file.
+// If [Update] is set, the golden file is written with the output instead, creating directories as needed.
+func AssertGolden(t testing.TB, engine mold.Engine, view string, data any, golden string) {
+	t.Helper()
+
+	out, err := RenderString(engine, view, data)
+	if err != nil {
+		t.Errorf("error rendering '%s': %v", view, err)
+		return
+	}
+
+	if Update {
+		if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+			t.Fatalf("error updating golden file '%s': %v", golden, err)
+		}
+		if err := os.WriteFile(golden, []byte(out), 0o644); err != nil {
+			t.Fatalf("error updating golden file '%s': %v", golden, err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(golden)
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("golden file '%s' not found, set moldtest.Update to create it", golden)
+		return
+	}
+	if err != nil {
+		t.Fatalf("error reading golden file '%s': %v", golden, err)
+	}
+	if out != string(expected) {
+		t.Errorf("rendering '%s' does not match golden file '%s'\ngot:\n%s\nwant:\n%s", view, golden, out, expected)
+	}
+}
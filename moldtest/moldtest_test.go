@@ -0,0 +1,87 @@
+package moldtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/deniskrumko/mold"
+)
+
+// recorder records test failures reported with Errorf instead of failing the test.
+type recorder struct {
+	testing.TB
+	failed bool
+}
+
+func (r *recorder) Helper()               {}
+func (r *recorder) Errorf(string, ...any) { r.failed = true }
+
+func newEngine() mold.Engine {
+	testFS := fstest.MapFS{
+		"layout.html": &fstest.MapFile{Data: []byte(`<main>{{render}}</main>`)},
+		"view.html":   &fstest.MapFile{Data: []byte(`Hello, {{.}}!`)},
+	}
+	return mold.Must(mold.New(testFS, mold.WithLayout("layout.html")))
+}
+
+func TestRenderString(t *testing.T) {
+	out, err := RenderString(newEngine(), "view.html", "John")
+	if err != nil {
+		t.Fatalf("RenderString() error = %v", err)
+	}
+	if expected := "<main>Hello, John!</main>"; out != expected {
+		t.Errorf("RenderString() got = %q, want %q", out, expected)
+	}
+
+	if _, err := RenderString(newEngine(), "missing.html", nil); err == nil {
+		t.Errorf("RenderString() expected error, got nil")
+	}
+}
+
+func TestAssertRender(t *testing.T) {
+	tests := []struct {
+		view     string
+		expected string
+		failed   bool
+	}{
+		{view: "view.html", expected: "<main>Hello, John!</main>"},
+		{view: "view.html", expected: "<main>Hello!</main>", failed: true},
+		{view: "missing.html", failed: true},
+	}
+
+	for _, tt := range tests {
+		r := &recorder{TB: t}
+		AssertRender(r, newEngine(), tt.view, "John", tt.expected)
+		if r.failed != tt.failed {
+			t.Errorf("AssertRender(%q, %q) failed = %v, want %v", tt.view, tt.expected, r.failed, tt.failed)
+		}
+	}
+}
+
+func TestAssertGolden(t *testing.T) {
+	golden := filepath.Join(t.TempDir(), "testdata", "view.golden")
+
+	r := &recorder{TB: t}
+	AssertGolden(r, newEngine(), "view.html", "John", golden)
+	if !r.failed {
+		t.Errorf("AssertGolden() expected failure for missing golden file")
+	}
+
+	Update = true
+	AssertGolden(t, newEngine(), "view.html", "John", golden)
+	Update = false
+
+	if b, err := os.ReadFile(golden); err != nil || string(b) != "<main>Hello, John!</main>" {
+		t.Fatalf("golden file = %q, %v", b, err)
+	}
+
+	AssertGolden(t, newEngine(), "view.html", "John", golden)
+
+	r = &recorder{TB: t}
+	AssertGolden(r, newEngine(), "view.html", "Jane", golden)
+	if !r.failed {
+		t.Errorf("AssertGolden() expected failure for mismatched output")
+	}
+}
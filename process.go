@@ -2,24 +2,77 @@ package mold
 
 import (
 	"fmt"
+	"io/fs"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"text/template/parse"
+	"unicode/utf8"
 )
 
-// processTree traverses the node tree and swaps render and partial declarations with equivalent template calls.
+// processTree traverses the node trees of the template and the templates it defines, and swaps render and
+// partial declarations with equivalent template calls.
 // It returns all referenced templates encountered during the traversal.
-func processTree(t *templateFile) ([]nestedFile, error) {
-	ts, err := processNode(t, nil, 0, t.Tree.Root)
+//
+// As the tree is rewritten in place, the referenced templates are retained and
+// returned on subsequent calls once the tree is successfully processed.
+//
+// Partial paths are resolved against the set, see [templateSet.resolve].
+func processTree(t *templateFile, set templateSet) ([]nestedFile, error) {
+	if t.processed {
+		return t.refs, nil
+	}
+
+	var ts []nestedFile
+	var err error
+	for _, tree := range append([]*parse.Tree{t.Tree}, definedTrees(t)...) {
+		var refs []nestedFile
+		refs, err = processNode(t, set, nil, 0, tree.Root)
+		ts = append(ts, refs...)
+		if err != nil {
+			break
+		}
+	}
 	if err != nil {
 		if err, ok := err.(posErr); ok {
-			line, col := pos(t.body, err.pos)
-			return ts, fmt.Errorf("%s:%d:%d: %s: %w", t.Name(), line, col, t.typ, err)
+			terr := newTemplateError(t, t.Name(), actionStart(t.body, err.pos, t.leftDelim), err.message)
+			terr.kind = string(t.typ)
+			if t.errorSnippets {
+				terr.snippet = snippet(t.source, terr.line, terr.col)
+			}
+			return ts, terr
 		}
+		return ts, err
 	}
 
+	t.refs, t.processed = ts, true
 	return ts, nil
 }
 
-func processNode(root *templateFile, parent *parse.ListNode, index int, node parse.Node) (ts []nestedFile, err error) {
+// definedTemplates returns the sorted names of the templates defined by the template file,
+// excluding the file itself and the templates of the base template, see [WithBaseTemplate].
+func definedTemplates(t *templateFile) (names []string) {
+	for _, tpl := range t.Templates() {
+		if tpl != t.Template && tpl.Tree != nil && tpl.Tree.ParseName == t.Tree.ParseName {
+			names = append(names, tpl.Name())
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// definedTrees returns the trees of the templates defined by the template file, see [definedTemplates].
+func definedTrees(t *templateFile) (trees []*parse.Tree) {
+	for _, name := range definedTemplates(t) {
+		trees = append(trees, t.Lookup(name).Tree)
+	}
+	return trees
+}
+
+func processNode(root *templateFile, set templateSet, parent *parse.ListNode, index int, node parse.Node) (ts []nestedFile, err error) {
 	// appendResult appends the specified templates to the list of template names when there are no errors
 	appendResult := func(t []nestedFile, err1 error) {
 		if err1 != nil {
@@ -33,50 +86,115 @@ func processNode(root *templateFile, parent *parse.ListNode, index int, node par
 	if a, ok := node.(*parse.ActionNode); ok {
 		if len(a.Pipe.Cmds) > 0 {
 			funcName, tname, _ := getActionArgs(a.Pipe.Cmds[0])
-			if err := processActionNode(root, parent, index, node, funcName); err != nil {
+			names, err := processActionNode(root, set, parent, index, node, funcName)
+			if err != nil {
 				return ts, err
 			}
-			if funcName == partialFunc.String() && tname != "" {
-				ts = append(ts, nestedFile{name: tname, typ: partialFunc})
-			} else if funcName == renderFunc.String() && tname != "" {
-				ts = append(ts, nestedFile{name: tname, typ: renderFunc})
+			if root.sourceComments && len(names) > 0 {
+				label := "section: " + names[0]
+				if isPartialFunc(funcName) {
+					label = "partial: " + names[0]
+				}
+				parent.Nodes[index] = newSourceComments(label, parent.Nodes[index])
+			}
+			for _, name := range names {
+				if isPartialFunc(funcName) && tname != "" {
+					ts = append(ts, nestedFile{name: name, typ: partialFunc})
+				} else if funcName == renderFunc.String() && tname != "" {
+					ts = append(ts, nestedFile{name: name, typ: renderFunc})
+				}
 			}
 		}
 	}
 
 	if w, ok := node.(*parse.WithNode); ok && w != nil {
-		appendResult(processNode(root, parent, index, w.List))
-		appendResult(processNode(root, parent, index, w.ElseList))
+		appendResult(processNode(root, set, parent, index, w.List))
+		appendResult(processNode(root, set, parent, index, w.ElseList))
 	}
 	if l, ok := node.(*parse.ListNode); ok && l != nil {
 		for i, n := range l.Nodes {
-			appendResult(processNode(root, l, i, n))
+			appendResult(processNode(root, set, l, i, n))
 		}
 	}
 	if i, ok := node.(*parse.IfNode); ok && i != nil {
-		appendResult(processNode(root, parent, index, i.List))
-		appendResult(processNode(root, parent, index, i.ElseList))
+		appendResult(processNode(root, set, parent, index, i.List))
+		appendResult(processNode(root, set, parent, index, i.ElseList))
 	}
 	if r, ok := node.(*parse.RangeNode); ok && r != nil {
-		appendResult(processNode(root, parent, index, r.List))
-		appendResult(processNode(root, parent, index, r.ElseList))
+		appendResult(processNode(root, set, parent, index, r.List))
+		appendResult(processNode(root, set, parent, index, r.ElseList))
 	}
 
 	return ts, err
 }
 
-func processActionNode(root *templateFile, parent *parse.ListNode, index int, node parse.Node, funcName string) error {
+// processActionNode replaces render and partial declarations with a template call.
+// It returns the names of the templates called, the first being the declared one.
+func processActionNode(root *templateFile, set templateSet, parent *parse.ListNode, index int, node parse.Node, funcName string) ([]string, error) {
 	actionNode := node.(*parse.ActionNode)
 	cmd := actionNode.Pipe.Cmds[0]
-	_, name, field := getActionArgs(cmd)
+	if isPartialFunc(funcName) {
+		mergeKwargs(cmd)
+	}
+	_, name, data := getActionArgs(cmd)
+
+	// components are partials in the component directory, the function is otherwise not handled
+	component := funcName == componentFunc
+	if component && root.componentDir == "" {
+		return nil, nil
+	}
+
+	if message := checkActionArgs(cmd, funcName); message != "" {
+		return nil, posErr{pos: int(actionNode.Pos), message: message}
+	}
+	if (funcName == partialFunc.String() || funcName == optionalPartialFunc || component) && len(cmd.Args) > 3 {
+		data = newPartialArgs(cmd.Args[2:])
+	}
+
+	if component && name != "" {
+		if !fs.ValidPath(name) {
+			return nil, posErr{pos: int(actionNode.Pos), message: fmt.Sprintf("invalid path to component file '%s', must be within the component directory", name)}
+		}
+		name = path.Join(root.componentDir, name)
+	} else if isPartialFunc(funcName) && name != "" {
+		name = set.resolve(root.path, name)
+		if !fs.ValidPath(name) {
+			return nil, posErr{pos: int(actionNode.Pos), message: fmt.Sprintf("invalid path to partial file '%s', must be within the root", name)}
+		}
+	}
+	if isPartialFunc(funcName) && name != "" {
+		if err := set.resolvePartial(root, name); err != nil {
+			return nil, err
+		}
+	}
+
+	if component {
+		funcName = partialFunc.String()
+	}
+
+	// optional partials render nothing if missing, otherwise they are regular partials
+	if funcName == optionalPartialFunc {
+		if name == "" {
+			return nil, posErr{pos: int(actionNode.Pos), message: `path to partial file is not specified`}
+		}
+		if set[name] == nil {
+			parent.Nodes[index] = &parse.TextNode{NodeType: parse.NodeText, Pos: actionNode.Pos}
+			return nil, nil
+		}
+		funcName = partialFunc.String()
+	}
+
+	if name == root.Name() && isPartialFunc(funcName) {
+		return nil, posErr{pos: int(actionNode.Pos), message: "cyclic reference"}
+	}
 
-	if name == root.Name() {
-		return posErr{pos: int(actionNode.Pos), message: "cyclic reference"}
+	if funcName == layoutsFunc && root.typ != viewType {
+		return nil, posErr{pos: int(actionNode.Pos), message: fmt.Sprintf("%s not supported", funcName)}
 	}
 
 	// validate for view and partial
-	if invalidFuncType(root.typ, funcName) {
-		return posErr{pos: int(actionNode.Pos), message: fmt.Sprintf("%s not supported", funcName)}
+	if invalidFuncType(root.typ, funcName) || root.markdown && (funcName == renderFunc.String() || isPartialFunc(funcName)) {
+		return nil, posErr{pos: int(actionNode.Pos), message: fmt.Sprintf("%s not supported", funcName)}
 	}
 
 	var arg parse.Node = &parse.DotNode{}
@@ -84,34 +202,184 @@ func processActionNode(root *templateFile, parent *parse.ListNode, index int, no
 	// only handle if the function name is render or partial
 	switch {
 	case funcName == partialFunc.String():
-		if field != nil {
-			arg = field
+		if data != nil {
+			arg = data
 		}
 		if name == "" {
-			return posErr{pos: int(actionNode.Pos), message: `path to partial file is not specified`}
+			return nil, posErr{pos: int(actionNode.Pos), message: `path to partial file is not specified`}
 		}
 	case funcName == renderFunc.String():
+		if data != nil {
+			arg = data
+		}
+		if name == "" {
+			name = root.bodySection
+		}
+	case funcName == cachedPartialFunc:
+		// executed and cached by a function call, the partial is added to the view as any other
+		if name == "" {
+			return nil, posErr{pos: int(actionNode.Pos), message: `path to partial file is not specified`}
+		}
+		if len(cmd.Args) < 3 {
+			return nil, posErr{pos: int(actionNode.Pos), message: `cache key is not specified`}
+		}
+		if t := set[name]; t != nil && t.markdown {
+			return nil, posErr{pos: int(actionNode.Pos), message: `markdown partials cannot be cached`}
+		}
+		if len(cmd.Args) > 4 {
+			arg = newPartialArgs(cmd.Args[3:])
+		} else if len(cmd.Args) > 3 {
+			arg = cmd.Args[3]
+		}
+		cmd.Args[0].(*parse.IdentifierNode).Ident = cachedPartialExecFunc
+		s := cmd.Args[1].(*parse.StringNode)
+		s.Text, s.Quoted = name, strconv.Quote(name)
+		cmd.Args = []parse.Node{cmd.Args[0], s, cmd.Args[2], arg}
+		return []string{name}, nil
+	case funcName == eachPartialFunc:
+		if name == "" {
+			return nil, posErr{pos: int(actionNode.Pos), message: `path to partial file is not specified`}
+		}
+		if len(cmd.Args) < 3 {
+			return nil, posErr{pos: int(actionNode.Pos), message: `collection is not specified`}
+		}
+		if t := set[name]; t != nil && t.markdown {
+			return nil, posErr{pos: int(actionNode.Pos), message: `markdown partials not supported`}
+		}
+		rn := newRangeNode(name, cmd.Args[2])
+		rn.Pos = actionNode.Pos
+		rn.Line = actionNode.Line
+		parent.Nodes[index] = rn
+		return []string{name}, nil
+	case funcName == partialOrFunc:
 		if name == "" {
-			name = "body"
+			return nil, posErr{pos: int(actionNode.Pos), message: `path to partial file is not specified`}
+		}
+		if len(cmd.Args) < 4 {
+			return nil, posErr{pos: int(actionNode.Pos), message: `fallback partial is not specified`}
+		}
+		fallback := set.resolve(root.path, cmd.Args[3].(*parse.StringNode).Text) // validated by checkActionArgs
+		if !fs.ValidPath(fallback) {
+			return nil, posErr{pos: int(actionNode.Pos), message: fmt.Sprintf("invalid path to partial file '%s', must be within the root", fallback)}
+		}
+		if fallback == root.Name() {
+			return nil, posErr{pos: int(actionNode.Pos), message: "cyclic reference"}
+		}
+		if err := set.resolvePartial(root, fallback); err != nil {
+			return nil, err
+		}
+		for _, n := range []string{name, fallback} {
+			if t := set[n]; t != nil && t.markdown {
+				return nil, posErr{pos: int(actionNode.Pos), message: `markdown partials not supported`}
+			}
 		}
+		wn := newPartialOrNode(name, fallback, cmd.Args[2])
+		wn.Pos = actionNode.Pos
+		wn.Line = actionNode.Line
+		parent.Nodes[index] = wn
+		return []string{name, fallback}, nil
 	default:
-		return nil
+		return nil, nil
+	}
+
+	// Markdown partials are executed and converted by a function call instead.
+	if t := set[name]; funcName == partialFunc.String() && t != nil && t.markdown {
+		cmd.Args[0].(*parse.IdentifierNode).Ident = markdownFunc
+		s := cmd.Args[1].(*parse.StringNode)
+		s.Text, s.Quoted = name, strconv.Quote(name)
+		cmd.Args = append(cmd.Args[:2], arg)
+		actionNode.Pipe.Cmds = []*parse.CommandNode{cmd}
+		return []string{name}, nil
 	}
 
 	cmd.Args = []parse.Node{arg}
 	actionNode.Pipe.Cmds = []*parse.CommandNode{cmd}
 
-	tn := &parse.TemplateNode{
-		NodeType: parse.NodeTemplate,
-		Pos:      actionNode.Pos,
-		Line:     actionNode.Line,
-		Name:     name,
-		Pipe:     actionNode.Pipe,
-	}
+	tn := newTemplateNode()
+	tn.Pos = actionNode.Pos
+	tn.Line = actionNode.Line
+	tn.Name = name
+	tn.Pipe = actionNode.Pipe
 
 	// replace the ActionNode with a TemplateNode.
 	parent.Nodes[index] = tn
-	return nil
+	return []string{name}, nil
+}
+
+// newPartialArgs returns a pipeline wrapping multiple data arguments of a partial into [partialArgs].
+//
+//	(_partialArgs arg1 arg2)
+func newPartialArgs(args []parse.Node) *parse.PipeNode {
+	funcs := map[string]any{partialArgsFunc: true}
+	trees, _ := parse.Parse("node", `{{template "node" (`+partialArgsFunc+`)}}`, "", "", funcs) // safe to ignore the err
+	pipe := trees["node"].Root.Nodes[0].(*parse.TemplateNode).Pipe.Cmds[0].Args[0].(*parse.PipeNode)
+	pipe.Cmds[0].Args = append(pipe.Cmds[0].Args, args...)
+	return pipe
+}
+
+// newSourceComments returns a list wrapping the node with HTML comments marking its boundaries,
+// see [WithSourceComments]. The comments are printed by a function call, as comments within
+// the text of templates are dropped by html/template.
+//
+//	{{_sourceComment "partial: card.html"}}{{template "card.html" .}}{{_sourceComment "/partial: card.html"}}
+func newSourceComments(label string, node parse.Node) *parse.ListNode {
+	funcs := map[string]any{sourceCommentFunc: true}
+	text := fmt.Sprintf("{{%[1]s %[2]s}}{{%[1]s %[3]s}}", sourceCommentFunc, strconv.Quote(label), strconv.Quote("/"+label))
+	trees, _ := parse.Parse("node", text, "", "", funcs) // safe to ignore the err
+	list := trees["node"].Root
+	list.Nodes = []parse.Node{list.Nodes[0], node, list.Nodes[1]}
+	list.Pos = node.Position()
+	return list
+}
+
+// newPartialOrNode returns a WithNode calling the template with the data,
+// or the fallback template if the data is the zero value of its type, see [newPartialOrData].
+// Unlike a bare WithNode, empty slices and maps are not zero values.
+//
+//	{{with $partialOr := _partialOrData data}}{{if $partialOr.Zero}}{{template "fallback" $partialOr.Data}}{{else}}{{template "name" $partialOr.Data}}{{end}}{{end}}
+func newPartialOrNode(name, fallback string, data parse.Node) *parse.WithNode {
+	funcs := map[string]any{partialOrDataFunc: true}
+	text := `{{with $partialOr := ` + partialOrDataFunc + `}}{{if $partialOr.Zero}}{{template "node" $partialOr.Data}}{{else}}{{template "node" $partialOr.Data}}{{end}}{{end}}`
+	trees, _ := parse.Parse("node", text, "", "", funcs) // safe to ignore the err
+	wn := trees["node"].Root.Nodes[0].(*parse.WithNode)
+	wn.Pipe.Cmds[0].Args = append(wn.Pipe.Cmds[0].Args, data)
+	in := wn.List.Nodes[0].(*parse.IfNode)
+	in.List.Nodes[0].(*parse.TemplateNode).Name = fallback
+	in.ElseList.Nodes[0].(*parse.TemplateNode).Name = name
+	return wn
+}
+
+// partialOrDataFunc wraps the data of partialOr, see [newPartialOrData].
+const partialOrDataFunc = "_partialOrData"
+
+// partialOrData is the data of partialOr, reporting whether it is the zero value of its type.
+type partialOrData struct {
+	Data any
+	Zero bool
+}
+
+func newPartialOrData(data any) partialOrData {
+	v := reflect.ValueOf(data)
+	return partialOrData{Data: data, Zero: !v.IsValid() || v.IsZero()}
+}
+
+// newRangeNode returns a RangeNode calling the template for each element of the collection.
+//
+//	{{range collection}}{{template "name" .}}{{end}}
+func newRangeNode(name string, collection parse.Node) *parse.RangeNode {
+	trees, _ := parse.Parse("node", `{{range .}}{{template "node" .}}{{end}}`, "", "") // safe to ignore the err
+	rn := trees["node"].Root.Nodes[0].(*parse.RangeNode)
+	rn.Pipe.Cmds[0].Args = []parse.Node{collection}
+	rn.List.Nodes[0].(*parse.TemplateNode).Name = name
+	return rn
+}
+
+// newTemplateNode returns an empty TemplateNode.
+// A TemplateNode must be associated with a parse tree to be printed or copied,
+// which is only possible by parsing one.
+func newTemplateNode() *parse.TemplateNode {
+	trees, _ := parse.Parse("node", `{{template "node"}}`, "", "") // safe to ignore the err
+	return trees["node"].Root.Nodes[0].(*parse.TemplateNode)
 }
 
 func invalidFuncType(typ templateType, funcName string) bool {
@@ -119,13 +387,117 @@ func invalidFuncType(typ templateType, funcName string) bool {
 	case viewType:
 		return funcName == renderFunc.String()
 	case partialType:
-		return funcName == renderFunc.String() || funcName == partialFunc.String()
+		return funcName == renderFunc.String()
 	}
 
 	return false
 }
 
-func getActionArgs(cmd *parse.CommandNode) (fn, file string, field *parse.FieldNode) {
+// checkActionArgs reports a malformed render or partial declaration, e.g. a path that is not a
+// string literal or too many arguments. It returns an empty string otherwise.
+// Partials accept any number of data arguments, see [newPartialArgs].
+func checkActionArgs(cmd *parse.CommandNode, funcName string) string {
+	maxArgs := 3
+	switch {
+	case funcName == renderFunc.String():
+		if len(cmd.Args) > 1 {
+			if _, ok := cmd.Args[1].(*parse.StringNode); !ok {
+				return "section name must be a string literal"
+			}
+		}
+	case isPartialFunc(funcName):
+		if len(cmd.Args) > 1 {
+			if _, ok := cmd.Args[1].(*parse.StringNode); !ok {
+				return "path to partial file must be a string literal"
+			}
+		}
+		if len(cmd.Args) > 2 {
+			if message := checkKwargs(cmd.Args[2:]); message != "" {
+				return message
+			}
+		}
+		switch funcName {
+		case eachPartialFunc:
+		case partialOrFunc:
+			maxArgs = 4
+			if len(cmd.Args) > 3 {
+				if _, ok := cmd.Args[3].(*parse.StringNode); !ok {
+					return "path to fallback partial file must be a string literal"
+				}
+			}
+		default:
+			maxArgs = len(cmd.Args)
+		}
+	default:
+		return ""
+	}
+	if len(cmd.Args) > maxArgs {
+		return fmt.Sprintf("%s: too many arguments", funcName)
+	}
+	return ""
+}
+
+// checkKwargs reports keyword arguments mixed with other data arguments of a partial,
+// or passed more than once.
+func checkKwargs(args []parse.Node) string {
+	for _, arg := range args {
+		kwargs := kwargsCall(arg)
+		if kwargs == nil {
+			continue
+		}
+		if len(args) > 1 {
+			return "keyword arguments cannot be mixed with other arguments"
+		}
+		seen := map[string]bool{}
+		for i := 1; i < len(kwargs.Args); i += 2 {
+			name := kwargs.Args[i].(*parse.StringNode).Text // quoted by rewriteKeywordArgs
+			if seen[name] {
+				return fmt.Sprintf("keyword argument %q passed more than once", name)
+			}
+			seen[name] = true
+		}
+	}
+	return ""
+}
+
+// mergeKwargs merges the keyword arguments of a partial, rewritten as separate calls of
+// partialKwargsFunc by [rewriteKeywordArgs], into a single call passed as the data argument.
+// Keyword arguments mixed with other arguments are left as is to be reported, see [checkKwargs].
+func mergeKwargs(cmd *parse.CommandNode) {
+	if len(cmd.Args) < 4 {
+		return
+	}
+	var merged *parse.CommandNode
+	for _, arg := range cmd.Args[2:] {
+		kwargs := kwargsCall(arg)
+		if kwargs == nil {
+			return
+		}
+		if merged == nil {
+			merged = kwargs
+		} else {
+			merged.Args = append(merged.Args, kwargs.Args[1:]...)
+		}
+	}
+	cmd.Args = cmd.Args[:3]
+}
+
+// kwargsCall returns the call of partialKwargsFunc of the argument, or nil if it is not one.
+func kwargsCall(arg parse.Node) *parse.CommandNode {
+	pipe, ok := arg.(*parse.PipeNode)
+	if !ok || len(pipe.Decl) > 0 || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) == 0 {
+		return nil
+	}
+	if ident, ok := pipe.Cmds[0].Args[0].(*parse.IdentifierNode); !ok || ident.Ident != partialKwargsFunc {
+		return nil
+	}
+	return pipe.Cmds[0]
+}
+
+// getActionArgs returns the function name, the template name and the data argument of the command.
+// The data argument may be any argument, e.g. a field, a variable, a literal or a parenthesized pipeline.
+// It is nil if not specified.
+func getActionArgs(cmd *parse.CommandNode) (fn, file string, data parse.Node) {
 	if len(cmd.Args) > 0 {
 		if i, ok := cmd.Args[0].(*parse.IdentifierNode); ok {
 			fn = i.Ident
@@ -137,13 +509,563 @@ func getActionArgs(cmd *parse.CommandNode) (fn, file string, field *parse.FieldN
 		}
 	}
 	if len(cmd.Args) > 2 {
-		if f, ok := cmd.Args[2].(*parse.FieldNode); ok {
-			field = f
-		}
+		data = cmd.Args[2]
 	}
 	return
 }
 
+// templateRefs returns the names of all templates invoked with a template action within the node tree.
+func templateRefs(node parse.Node) (names []string) {
+	switch n := node.(type) {
+	case *parse.TemplateNode:
+		names = append(names, n.Name)
+	case *parse.ListNode:
+		if n != nil {
+			for _, n := range n.Nodes {
+				names = append(names, templateRefs(n)...)
+			}
+		}
+	case *parse.IfNode:
+		names = append(names, templateRefs(n.List)...)
+		names = append(names, templateRefs(n.ElseList)...)
+	case *parse.WithNode:
+		names = append(names, templateRefs(n.List)...)
+		names = append(names, templateRefs(n.ElseList)...)
+	case *parse.RangeNode:
+		names = append(names, templateRefs(n.List)...)
+		names = append(names, templateRefs(n.ElseList)...)
+	}
+	return names
+}
+
+// unconditionalRefs returns the names of the templates invoked within the node tree,
+// outside of conditional actions, i.e. invoked whenever the tree is executed.
+func unconditionalRefs(node parse.Node) (names []string) {
+	switch n := node.(type) {
+	case *parse.TemplateNode:
+		names = append(names, n.Name)
+	case *parse.ListNode:
+		if n != nil {
+			for _, n := range n.Nodes {
+				names = append(names, unconditionalRefs(n)...)
+			}
+		}
+	}
+	return names
+}
+
+// cachedPartialFunc renders a partial memoized by a key, see [WithPartialCache].
+// It is swapped with cachedPartialExecFunc, bound to each assembled view.
+const (
+	cachedPartialFunc     = "cachedPartial"
+	cachedPartialExecFunc = "_cachedPartial"
+)
+
+// isPartialFunc reports whether the function renders a partial.
+func isPartialFunc(funcName string) bool {
+	switch funcName {
+	case partialFunc.String(), cachedPartialFunc, optionalPartialFunc, eachPartialFunc, partialOrFunc, componentFunc:
+		return true
+	}
+	return false
+}
+
+// sourceCommentFunc prints an HTML comment marking the boundary of a partial or section, see [WithSourceComments].
+const sourceCommentFunc = "_sourceComment"
+
+// partialArgsFunc wraps multiple data arguments of a partial, see [newPartialArgs].
+const partialArgsFunc = "_partialArgs"
+
+// partialArgs is the data of a partial passed multiple arguments, exposed as .Args.
+//
+//	{{partial "card.html" .Title .Body}}
+//	<h2>{{index .Args 0}}</h2>
+type partialArgs struct {
+	Args []any
+}
+
+// partialKwargsFunc wraps the keyword arguments of a partial in a map, see [rewriteKeywordArgs].
+const partialKwargsFunc = "_partialKwargs"
+
+// partialKwargs returns the keyword arguments of a partial, passed as pairs of names and values.
+func partialKwargs(pairs ...any) map[string]any {
+	kwargs := make(map[string]any, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		name, _ := pairs[i].(string)
+		kwargs[name] = pairs[i+1]
+	}
+	return kwargs
+}
+
+// keywordArg matches a keyword argument of a partial, e.g. label="Save".
+var keywordArg = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)=(.+)$`)
+
+// rewriteKeywordArgs rewrites each keyword argument of partials as a call of partialKwargsFunc,
+// as they are not supported by the template parser. The calls are merged once parsed, see [mergeKwargs].
+// Arguments are rewritten in place, the lines of the body are retained, and the edits map positions
+// in the rewritten body to the source, see [sourcePos].
+//
+//	{{partial "button.html" label="Save" kind=.Kind}}
+//	{{partial "button.html" (_partialKwargs "label" "Save") (_partialKwargs "kind" .Kind)}}
+func rewriteKeywordArgs(source, left, right string) (string, []edit) {
+	var (
+		b     strings.Builder
+		edits []edit
+		prev  int // end of the source written
+	)
+	for pos := 0; ; {
+		start := strings.Index(source[pos:], left)
+		if start < 0 {
+			break
+		}
+		start += pos + len(left)
+		end := actionEnd(source, start, right)
+		if end < 0 {
+			break
+		}
+		pos = end + len(right)
+
+		for _, kw := range keywordArgs(source[start:end]) {
+			from, to := start+kw.start, start+kw.end
+			b.WriteString(source[prev:from])
+			replacement := "(" + partialKwargsFunc + " " + strconv.Quote(kw.name) + " "
+			edits = append(edits, edit{at: b.Len(), from: len(kw.name) + 1, to: len(replacement)})
+			b.WriteString(replacement)
+			b.WriteString(source[from+len(kw.name)+1 : to])
+			edits = append(edits, edit{at: b.Len(), to: 1})
+			b.WriteString(")")
+			prev = to
+		}
+	}
+	if len(edits) == 0 {
+		return source, nil
+	}
+	b.WriteString(source[prev:])
+	return b.String(), edits
+}
+
+// edit is a replacement of text of the source of a template, see [rewriteKeywordArgs].
+type edit struct {
+	at   int // position in the rewritten body
+	from int // length of the replaced text in the source
+	to   int // length of the replacement in the rewritten body
+}
+
+// sourcePos returns the position in the source of the position in the body rewritten with the edits.
+// Positions within a replacement are mapped to the start of the replaced text.
+func sourcePos(edits []edit, pos int) int {
+	shift := 0
+	for _, e := range edits {
+		if pos < e.at {
+			break
+		}
+		if pos < e.at+e.to {
+			return e.at - shift
+		}
+		shift += e.to - e.from
+	}
+	return pos - shift
+}
+
+// actionEnd returns the position of the right delimiter of the action starting at pos,
+// skipping comments and quoted text, or -1 if there is none.
+func actionEnd(body string, pos int, right string) int {
+	if rest := strings.TrimPrefix(body[pos:], "- "); strings.HasPrefix(rest, "/*") {
+		i := strings.Index(rest, "*/")
+		if i < 0 {
+			return -1
+		}
+		offset := len(body) - len(rest) + i + 2
+		if j := strings.Index(body[offset:], right); j >= 0 {
+			return offset + j
+		}
+		return -1
+	}
+
+	for i := pos; i < len(body); i++ {
+		switch body[i] {
+		case '"', '`', '\'':
+			i = quoteEnd(body, i)
+		default:
+			if strings.HasPrefix(body[i:], right) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// quoteEnd returns the position of the closing quote of the quoted text starting at pos,
+// or the end of the body if it is not closed.
+func quoteEnd(body string, pos int) int {
+	quote := body[pos]
+	for i := pos + 1; i < len(body); i++ {
+		switch body[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case quote:
+			return i
+		}
+	}
+	return len(body)
+}
+
+// keywordArgSpan is a keyword argument within the text of an action.
+type keywordArgSpan struct {
+	name       string
+	start, end int
+}
+
+// keywordArgs returns the keyword arguments of the action, if calling a partial.
+func keywordArgs(action string) (kwargs []keywordArgSpan) {
+	operands := operandSpans(action)
+	if len(operands) > 0 && action[operands[0][0]:operands[0][1]] == "-" {
+		operands = operands[1:] // trim marker
+	}
+	if len(operands) < 3 || !slices.Contains([]string{partialFunc.String(), optionalPartialFunc, componentFunc}, action[operands[0][0]:operands[0][1]]) {
+		return nil
+	}
+	for _, op := range operands[2:] {
+		if m := keywordArg.FindStringSubmatch(action[op[0]:op[1]]); m != nil {
+			kwargs = append(kwargs, keywordArgSpan{name: m[1], start: op[0], end: op[1]})
+		}
+	}
+	return kwargs
+}
+
+// operandSpans returns the start and end positions of the operands of the text of an action,
+// separated by spaces, keeping quoted text and parenthesized pipelines whole.
+func operandSpans(text string) (spans [][2]int) {
+	depth, start := 0, -1
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			if depth == 0 && start >= 0 {
+				spans = append(spans, [2]int{start, i})
+				start = -1
+			}
+			continue
+		case c == '"' || c == '`' || c == '\'':
+			if start < 0 {
+				start = i
+			}
+			i = quoteEnd(text, i)
+			continue
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(text)})
+	}
+	return spans
+}
+
+// eachPartialFunc renders a partial for each element of a collection.
+//
+//	{{eachPartial "item.html" .Items}}
+const eachPartialFunc = "eachPartial"
+
+// partialOrFunc renders a partial, or a fallback partial if the data is the zero value of its type.
+//
+//	{{partialOr "avatar.html" .User.Photo "default_avatar.html"}}
+const partialOrFunc = "partialOr"
+
+// optionalPartialFunc renders a partial if it exists, and nothing otherwise.
+// The partial is resolved when the view is assembled by [build], not on each render.
+const optionalPartialFunc = "optionalPartial"
+
+// componentFunc renders a partial in the component directory, see [WithComponentDir].
+//
+//	{{component "button.html" .}}
+const componentFunc = "component"
+
+// layoutsFunc is the directive declaring the stack of layouts wrapping a view.
+const layoutsFunc = "layouts"
+
+// layoutStack returns the layouts declared by the view with the layouts directive,
+// ordered from the outermost to the innermost.
+//
+//	{{layouts "outer_layout.html" "card_layout.html"}}
+func layoutStack(t *templateFile) (names []string, err error) {
+	found := false
+	for _, node := range t.Tree.Root.Nodes {
+		a, ok := node.(*parse.ActionNode)
+		if !ok || len(a.Pipe.Cmds) != 1 {
+			continue
+		}
+		args := a.Pipe.Cmds[0].Args
+		if ident, ok := args[0].(*parse.IdentifierNode); !ok || ident.Ident != layoutsFunc {
+			continue
+		}
+		if found {
+			return nil, fmt.Errorf("%s: %s declared more than once", t.Name(), layoutsFunc)
+		}
+		found = true
+		if len(args) < 2 {
+			return nil, fmt.Errorf("%s: %s: no layout specified", t.Name(), layoutsFunc)
+		}
+		for _, arg := range args[1:] {
+			s, ok := arg.(*parse.StringNode)
+			if !ok {
+				return nil, fmt.Errorf("%s: %s: layout must be a string literal", t.Name(), layoutsFunc)
+			}
+			names = append(names, s.Text)
+		}
+	}
+	return names, nil
+}
+
+// renameTemplateRefs renames the templates invoked with a template action within the node tree.
+func renameTemplateRefs(node parse.Node, from, to string) {
+	switch n := node.(type) {
+	case *parse.TemplateNode:
+		if n.Name == from {
+			n.Name = to
+		}
+	case *parse.ListNode:
+		if n != nil {
+			for _, n := range n.Nodes {
+				renameTemplateRefs(n, from, to)
+			}
+		}
+	case *parse.IfNode:
+		renameTemplateRefs(n.List, from, to)
+		renameTemplateRefs(n.ElseList, from, to)
+	case *parse.WithNode:
+		renameTemplateRefs(n.List, from, to)
+		renameTemplateRefs(n.ElseList, from, to)
+	case *parse.RangeNode:
+		renameTemplateRefs(n.List, from, to)
+		renameTemplateRefs(n.ElseList, from, to)
+	}
+}
+
+// builtinFuncNames are the functions predefined by text/template.
+var builtinFuncNames = []string{
+	"and", "call", "html", "index", "slice", "js", "len", "not", "or",
+	"print", "printf", "println", "urlquery", "eq", "ge", "gt", "le", "lt", "ne",
+}
+
+// checkFuncs reports every function called within the template that is neither a builtin nor in funcMap,
+// as well as render and partial declarations not used as a standalone action.
+func checkFuncs(t *templateFile, funcMap map[string]any) (errs []error) {
+	for _, tpl := range t.Templates() {
+		if tpl.Tree == nil {
+			continue
+		}
+		funcIdents(tpl.Tree.Root, false, func(ident *parse.IdentifierNode, directive bool) {
+			var message string
+			switch name := ident.Ident; {
+			case name == renderFunc.String() || name == partialFunc.String():
+				if !directive {
+					message = fmt.Sprintf("%s must be used as a standalone action", name)
+				}
+			case slices.Contains(builtinFuncNames, name):
+			case funcMap[name] != nil:
+			default:
+				message = fmt.Sprintf("function %q not defined", name)
+			}
+			if message != "" {
+				errs = append(errs, newTemplateError(t, t.path, int(ident.Pos), message))
+			}
+		})
+	}
+	return errs
+}
+
+// callsFuncs reports whether the template calls any of the functions in funcMap.
+func callsFuncs(t *templateFile, funcMap map[string]any) (found bool) {
+	if len(funcMap) == 0 {
+		return false
+	}
+	for _, tpl := range t.Templates() {
+		if tpl.Tree == nil {
+			continue
+		}
+		funcIdents(tpl.Tree.Root, false, func(ident *parse.IdentifierNode, _ bool) {
+			if _, ok := funcMap[ident.Ident]; ok {
+				found = true
+			}
+		})
+	}
+	return found
+}
+
+// funcIdents calls fn for every function identifier within the node tree.
+// directive reports whether the identifier is the first word of an action.
+func funcIdents(node parse.Node, directive bool, fn func(ident *parse.IdentifierNode, directive bool)) {
+	switch n := node.(type) {
+	case *parse.IdentifierNode:
+		fn(n, directive)
+	case *parse.ListNode:
+		if n != nil {
+			for _, n := range n.Nodes {
+				funcIdents(n, false, fn)
+			}
+		}
+	case *parse.ActionNode:
+		if n.Pipe != nil && len(n.Pipe.Decl) == 0 && len(n.Pipe.Cmds) > 0 {
+			for i, cmd := range n.Pipe.Cmds {
+				for j, arg := range cmd.Args {
+					funcIdents(arg, i == 0 && j == 0, fn)
+				}
+			}
+			return
+		}
+		funcIdents(n.Pipe, false, fn)
+	case *parse.IfNode:
+		funcIdents(&n.BranchNode, false, fn)
+	case *parse.RangeNode:
+		funcIdents(&n.BranchNode, false, fn)
+	case *parse.WithNode:
+		funcIdents(&n.BranchNode, false, fn)
+	case *parse.BranchNode:
+		funcIdents(n.Pipe, false, fn)
+		funcIdents(n.List, false, fn)
+		funcIdents(n.ElseList, false, fn)
+	case *parse.TemplateNode:
+		funcIdents(n.Pipe, false, fn)
+	case *parse.PipeNode:
+		if n != nil {
+			for _, cmd := range n.Cmds {
+				funcIdents(cmd, false, fn)
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			funcIdents(arg, false, fn)
+		}
+	case *parse.ChainNode:
+		funcIdents(n.Node, false, fn)
+	}
+}
+
+// parseDirective returns the value of the directive declared in the leading comments of the template body.
+//
+//	{{/* key: value */}}
+func parseDirective(body, key string) string {
+	for {
+		body = strings.TrimLeft(body, " \t\r\n")
+		prefix := "{{/*"
+		if strings.HasPrefix(body, "{{- /*") {
+			prefix = "{{- /*"
+		} else if !strings.HasPrefix(body, prefix) {
+			return ""
+		}
+		comment, rest, ok := strings.Cut(body[len(prefix):], "*/")
+		if !ok {
+			return ""
+		}
+		k, value, ok := strings.Cut(comment, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(k), key) {
+			return strings.TrimSpace(value)
+		}
+		_, body, _ = strings.Cut(rest, "}}")
+	}
+}
+
+// expectsFunc validates the data of a partial against the fields declared with the expects directive,
+// see [WithStrictPartialArgs].
+const expectsFunc = "_expects"
+
+// parseExpects returns the fields declared by the template with the expects directive.
+//
+//	{{/* expects: .Name .Email */}}
+func parseExpects(body string) (fields []string) {
+	for _, f := range strings.Fields(parseDirective(body, "expects")) {
+		fields = append(fields, strings.TrimPrefix(f, "."))
+	}
+	return fields
+}
+
+// checkExpects reports every field of the data used by the template that is not declared
+// with the expects directive.
+func checkExpects(t *templateFile, expects []string) (errs []error) {
+	dataFields(t.Tree.Root, func(node parse.Node, field string) {
+		if !slices.Contains(expects, field) {
+			errs = append(errs, newTemplateError(t, t.path, int(node.Position()), fmt.Sprintf("field .%s not declared with expects", field)))
+		}
+	})
+	return errs
+}
+
+// dataFields calls fn for every field of the data accessed within the node tree, i.e. fields of
+// the dot outside of range and with blocks, and fields of the $ variable.
+func dataFields(node parse.Node, fn func(node parse.Node, field string)) {
+	switch n := node.(type) {
+	case *parse.FieldNode:
+		fn(n, n.Ident[0])
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			fn(n, n.Ident[1])
+		}
+	case *parse.ListNode:
+		if n != nil {
+			for _, n := range n.Nodes {
+				dataFields(n, fn)
+			}
+		}
+	case *parse.ActionNode:
+		dataFields(n.Pipe, fn)
+	case *parse.IfNode:
+		dataFields(n.Pipe, fn)
+		dataFields(n.List, fn)
+		dataFields(n.ElseList, fn)
+	case *parse.RangeNode:
+		// the dot changes within the block, only $ refers to the data
+		dataFields(n.Pipe, fn)
+		rootFields(n.List, fn)
+		dataFields(n.ElseList, fn)
+	case *parse.WithNode:
+		dataFields(n.Pipe, fn)
+		rootFields(n.List, fn)
+		dataFields(n.ElseList, fn)
+	case *parse.TemplateNode:
+		dataFields(n.Pipe, fn)
+	case *parse.PipeNode:
+		if n != nil {
+			for _, cmd := range n.Cmds {
+				dataFields(cmd, fn)
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			dataFields(arg, fn)
+		}
+	case *parse.ChainNode:
+		dataFields(n.Node, fn)
+	}
+}
+
+// rootFields is like dataFields, but only reports fields of the $ variable.
+func rootFields(node parse.Node, fn func(node parse.Node, field string)) {
+	dataFields(node, func(node parse.Node, field string) {
+		if _, ok := node.(*parse.VariableNode); ok {
+			fn(node, field)
+		}
+	})
+}
+
+// insertExpects prepends a call validating the data against the expected fields to the template.
+// The call is parsed, as nodes must be associated with a parse tree to be printed.
+func insertExpects(t *templateFile, expects []string) {
+	args := []string{expectsFunc, strconv.Quote(t.path), "."}
+	for _, f := range expects {
+		args = append(args, strconv.Quote(f))
+	}
+	funcs := map[string]any{expectsFunc: true}
+	trees, _ := parse.Parse("node", "{{"+strings.Join(args, " ")+"}}", "", "", funcs) // safe to ignore the err
+	t.Tree.Root.Nodes = append([]parse.Node{trees["node"].Root.Nodes[0]}, t.Tree.Root.Nodes...)
+}
+
 // posErr tracks the position in the template file when a parse error occurs.
 type posErr struct {
 	pos     int
@@ -154,6 +1076,49 @@ func (p posErr) Error() string {
 	return p.message
 }
 
+// actionStart returns the position of the left delimiter of the action at pos,
+// as the position of an action is that of its first word.
+func actionStart(body string, pos int, left string) int {
+	if pos > len(body) || left == "" {
+		return pos
+	}
+	if i := strings.LastIndex(body[:pos], left); i >= 0 {
+		return i
+	}
+	return pos
+}
+
+// newTemplateError returns an error at the position in the body of the template, reported under name.
+// The position is reported in the source, see [sourcePos].
+func newTemplateError(t *templateFile, name string, at int, message string) *TemplateError {
+	line, col := pos(t.source, sourcePos(t.edits, at))
+	return &TemplateError{file: name, line: line, col: col, message: message, severity: SeverityError}
+}
+
+// parseErrLine matches an error of the template parser, e.g. "template: view.html:3: unexpected EOF".
+var parseErrLine = regexp.MustCompile(`(?s)^template: (.*?):(\d+): (.*)$`)
+
+// newParseError returns the error of the template parser as an error in the source, reported under name.
+// The parser reports the line only, the column is that of the first action on the line.
+func newParseError(name, source, left string, snippets bool, err error) error {
+	m := parseErrLine.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	line, _ := strconv.Atoi(m[2])
+	col := 1
+	if lines := strings.Split(source, "\n"); line >= 1 && line <= len(lines) {
+		if i := strings.Index(lines[line-1], left); i >= 0 {
+			col = utf8.RuneCountInString(lines[line-1][:i]) + 1
+		}
+	}
+	terr := &TemplateError{file: name, line: line, col: col, message: m[3], severity: SeverityError}
+	if snippets {
+		terr.snippet = snippet(source, line, col)
+	}
+	return terr
+}
+
 func pos(body string, pos int) (line int, col int) {
 	line = 1
 	col = 1
@@ -172,6 +1137,29 @@ func pos(body string, pos int) (line int, col int) {
 	return line, col
 }
 
+// snippet returns the line of the body with a caret pointing at the column, indented with a tab.
+// Tabs preceding the column are retained for the caret to line up.
+func snippet(body string, line, col int) string {
+	lines := strings.Split(body, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	text := strings.TrimSuffix(lines[line-1], "\r")
+
+	var caret strings.Builder
+	for i, char := range []rune(text) {
+		if i >= col-1 {
+			break
+		}
+		if char == '\t' {
+			caret.WriteRune('\t')
+		} else {
+			caret.WriteRune(' ')
+		}
+	}
+	return "\t" + text + "\n\t" + caret.String() + "^"
+}
+
 type nestingFunc string
 
 func (t nestingFunc) String() string { return string(t) }
@@ -2,6 +2,7 @@ package mold
 
 import (
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -71,3 +72,53 @@ func TestPos(t *testing.T) {
 		})
 	}
 }
+
+func TestRewriteKeywordArgs(t *testing.T) {
+	tests := []struct {
+		body     string
+		left     string
+		right    string
+		expected string
+	}{
+		{
+			body:     `<a href="/">{{partial "btn.html" label="Save" kind=.Kind}}</a>`,
+			expected: `<a href="/">{{partial "btn.html" (_partialKwargs "label" "Save") (_partialKwargs "kind" .Kind)}}</a>`,
+		},
+		{
+			body:     "{{- component \"btn.html\"  label=\"a }} b\"\n\tn=(len .Items) -}}",
+			expected: "{{- component \"btn.html\"  (_partialKwargs \"label\" \"a }} b\")\n\t(_partialKwargs \"n\" (len .Items)) -}}",
+		},
+		{
+			body:     "{{/* {{partial \"btn.html\" a=1}} */}}{{partial `x.html` .}}{{printf \"%s\" a=1}}",
+			expected: "{{/* {{partial \"btn.html\" a=1}} */}}{{partial `x.html` .}}{{printf \"%s\" a=1}}",
+		},
+		{
+			body:     `{{partial "btn.html" "a=1"}}{{optionalPartial "x.html" a=}}`,
+			expected: `{{partial "btn.html" "a=1"}}{{optionalPartial "x.html" a=}}`,
+		},
+		{
+			body:     `{{partial "btn.html" a='x'}} {{`,
+			expected: `{{partial "btn.html" (_partialKwargs "a" 'x')}} {{`,
+		},
+		{
+			body:     `[[partial "btn.html" a=1]] {{partial "btn.html" a=1}}`,
+			left:     "[[",
+			right:    "]]",
+			expected: `[[partial "btn.html" (_partialKwargs "a" 1)]] {{partial "btn.html" a=1}}`,
+		},
+	}
+	for _, tt := range tests {
+		left, right := tt.left, tt.right
+		if left == "" {
+			left, right = "{{", "}}"
+		}
+		got, edits := rewriteKeywordArgs(tt.body, left, right)
+		if got != tt.expected {
+			t.Errorf("rewriteKeywordArgs(%q) = %q, want %q", tt.body, got, tt.expected)
+		}
+		// positions following the rewrites are mapped back to the source
+		if i, j := strings.LastIndex(got, right), strings.LastIndex(tt.body, right); i >= 0 && sourcePos(edits, i) != j {
+			t.Errorf("sourcePos(%d) = %d, want %d for %q", i, sourcePos(edits, i), j, tt.body)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package mold
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+var (
+	_ fs.FS        = themeFS(nil)
+	_ fs.ReadDirFS = themeFS(nil)
+	_ fs.StatFS    = themeFS(nil)
+)
+
+// themeFS layers the directories of themes, from child to parent, see [WithTheme].
+// A file in a theme overrides the file at the same path in its parents.
+type themeFS []fs.FS
+
+// newThemeFS returns the layered filesystem of the theme directories in fsys, from child to parent.
+func newThemeFS(fsys fs.FS, themes []string) (themeFS, error) {
+	layers := make(themeFS, 0, len(themes))
+	for _, theme := range themes {
+		info, err := fs.Stat(fsys, theme)
+		if err != nil {
+			return nil, fmt.Errorf("error setting theme '%s': %w", theme, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("theme '%s' is not a directory", theme)
+		}
+		sub, err := fs.Sub(fsys, theme)
+		if err != nil {
+			return nil, fmt.Errorf("error setting theme '%s': %w", theme, err)
+		}
+		layers = append(layers, sub)
+	}
+	return layers, nil
+}
+
+// Open implements fs.FS.
+// It opens the file in the first theme containing it. Directories are not merged, use ReadDir instead.
+func (s themeFS) Open(name string) (fs.File, error) {
+	var errs []error
+	for _, layer := range s {
+		f, err := layer.Open(name)
+		if err == nil {
+			return f, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, s.err("open", name, errs)
+}
+
+// ReadDir implements fs.ReadDirFS.
+// The entries of the directory in all themes are merged, the first theme containing an entry wins.
+func (s themeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var (
+		entries []fs.DirEntry
+		seen    = map[string]bool{}
+		found   bool
+		errs    []error
+	)
+	for _, layer := range s {
+		layerEntries, err := fs.ReadDir(layer, name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		found = true
+		for _, e := range layerEntries {
+			if !seen[e.Name()] {
+				seen[e.Name()] = true
+				entries = append(entries, e)
+			}
+		}
+	}
+	if !found {
+		return nil, s.err("readdir", name, errs)
+	}
+
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, nil
+}
+
+// Stat implements fs.StatFS.
+func (s themeFS) Stat(name string) (fs.FileInfo, error) {
+	var errs []error
+	for _, layer := range s {
+		info, err := fs.Stat(layer, name)
+		if err == nil {
+			return info, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, s.err("stat", name, errs)
+}
+
+// err returns the error of the operation failing in all themes,
+// a not found error if the name is missing from all of them.
+func (s themeFS) err(op, name string, errs []error) error {
+	for _, err := range errs {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
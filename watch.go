@@ -0,0 +1,149 @@
+package mold
+
+import (
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// default interval between checks for changes of the filesystem, see [WithWatch]
+var defaultWatchInterval = time.Second
+
+// watcher polls the filesystem for changes and rebuilds the engine.
+type watcher struct {
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+	err     error // the error of the last rebuild, guarded by the engine mutex
+}
+
+// fileStamp identifies a version of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// watch starts polling the filesystem of the engine for changes.
+func (m *moldEngine) watch(interval time.Duration) {
+	w := &watcher{done: make(chan struct{}), stopped: make(chan struct{})}
+	m.watcher = w
+	c := m.c
+	stamps := snapshot(&c)
+
+	go func() {
+		defer close(w.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+			}
+			current := snapshot(&c)
+			if maps.Equal(current, stamps) {
+				continue
+			}
+			stamps = current
+			m.rebuild()
+		}
+	}()
+}
+
+// watchable reports whether the filesystem is a directory of the operating system, see [os.DirFS].
+// Other filesystems, e.g. [embed.FS], do not change and are not polled.
+// It is checked before the filesystem is wrapped, see [WithRoot] and [WithTheme].
+func watchable(fsys fs.FS) bool {
+	return reflect.TypeOf(fsys) == reflect.TypeOf(os.DirFS("."))
+}
+
+// snapshot returns the stamps of the template files in the filesystem.
+// Files that cannot be read are omitted, their changes are detected once readable again.
+//
+// The filesystem is polled rather than notified of changes, as notifications are specific to each
+// operating system and not provided by the standard library. Other files, e.g. static assets,
+// are not stamped, so that each poll only stats the files parsed by the engine.
+func snapshot(c *Config) map[string]fileStamp {
+	stamps := map[string]fileStamp{}
+	_ = fs.WalkDir(c.fs, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !c.includeHidden.val && strings.HasPrefix(d.Name(), ".") && path != "." {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || (matchExt(c.exts.val, d.Name()) == "" && !isMarkdown(c, path)) {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			stamps[path] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		}
+		return nil
+	})
+	return stamps
+}
+
+// rebuild parses all templates again, including the layout, and swaps them in place of the current ones.
+// On error, the current templates are retained and the error is reported by renders until a rebuild succeeds.
+func (m *moldEngine) rebuild() {
+	m.mu.RLock()
+	c := m.c
+	m.mu.RUnlock()
+
+	// layouts not read from the filesystem do not change
+	if c.layout.val != DefaultLayoutName && !c.layoutReader.set {
+		f, err := readFile(c.fs, c.layout.val, c.maxFileSize.val)
+		if err != nil {
+			m.setWatchErr(fmt.Errorf("error reading layout file '%s': %w", c.layout.val, err))
+			return
+		}
+		c.layoutRaw = f
+	}
+
+	n, err := build(c)
+	if err != nil {
+		m.setWatchErr(err)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.set, m.layout, m.layoutFiles, m.folded, m.stacks, m.watcher.err = n.set, n.layout, n.layoutFiles, n.folded, n.stacks, nil
+	m.c.layoutRaw = c.layoutRaw
+	m.views.purge()
+	m.variants.purge()
+	if m.partials != nil {
+		m.partials.purge()
+	}
+	m.c.logger.val.Debug("rebuilt templates")
+}
+
+func (m *moldEngine) setWatchErr(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.watcher.err = err
+	m.c.logger.val.Error("error rebuilding templates", "error", err)
+}
+
+// watchErr returns the error of the last rebuild, if watching the filesystem.
+func (m *moldEngine) watchErr() error {
+	if m.watcher == nil {
+		return nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.watcher.err
+}